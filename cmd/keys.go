@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/store"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/spf13/cobra"
+)
+
+var recipientType string
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manages the recipients of all encrypted files",
+	Long: `Manages the age, PGP, KMS and Vault recipients of secrets.yaml, a SOPS-encrypted plato.yaml
+and every *.sops_enc file in 'plato.source', all at once.
+
+Creation rules in .sops.yaml used by any of these files are updated too, and files with a creation rule
+are re-encrypted for exactly its recipients. Files without one are compared to secrets.yaml.`,
+}
+
+var keysListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "Lists the recipients of all encrypted files and reports diverging ones",
+	Long:    `Lists the recipients of all encrypted files, exits with 1 if any of them diverge from their creation rule or secrets.yaml.`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		initKeys()
+		files, err := store.ListRecipients()
+		if err != nil {
+			log.Fatalf("could not list recipients: %v", err)
+		}
+
+		diverging := 0
+		for _, f := range files {
+			log.Infof("[%s]", color.Magenta(f.File))
+			for _, recipient := range f.Recipients {
+				log.Infof("  %s", recipient)
+			}
+			if f.Diverges() {
+				diverging++
+				expected := "secrets.yaml"
+				if f.Rule {
+					expected = "its creation rule"
+				}
+				log.Warnf("[%s] diverges from %s, missing: [%s], unexpected: [%s]", color.Magenta(f.File), expected,
+					color.Red(joinRecipients(f.Missing)), color.Red(joinRecipients(f.Extra)))
+			}
+		}
+		if diverging > 0 {
+			log.Warnf("%d of %d file(s) diverge", diverging, len(files))
+			os.Exit(1)
+		}
+		log.Infof("all %d file(s) have the expected recipients", len(files))
+	},
+}
+
+var keysAddCmd = &cobra.Command{
+	Use:   "add <recipient>",
+	Short: "Adds a recipient to all encrypted files",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		initKeys()
+		if err := store.AddRecipient(parseRecipient(args[0])); err != nil {
+			log.Fatalf("could not add recipient: %v", err)
+		}
+	},
+}
+
+var keysRemoveCmd = &cobra.Command{
+	Use:     "remove <recipient>",
+	Aliases: []string{"rm"},
+	Short:   "Removes a recipient from all encrypted files",
+	Long:    `Removes a recipient from all encrypted files, every file it has been removed from also gets a new data key.`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		initKeys()
+		if err := store.RemoveRecipient(parseRecipient(args[0])); err != nil {
+			log.Fatalf("could not remove recipient: %v", err)
+		}
+	},
+}
+
+var keysRotateDataKeyCmd = &cobra.Command{
+	Use:   "rotate-data-key",
+	Short: "Generates new data keys for all encrypted files",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		initKeys()
+		if err := store.RotateDataKeys(); err != nil {
+			log.Fatalf("could not rotate data keys: %v", err)
+		}
+	},
+}
+
+// initKeys loads the plato configuration without decrypting secrets.yaml, only its SOPS metadata is needed
+func initKeys() {
+	config.SetUsedKeysFunc(func() ([][]string, bool) { return nil, false })
+	config.InitConfig()
+}
+
+func parseRecipient(key string) sops.Recipient {
+	recipient, err := sops.ParseRecipient(key, recipientType)
+	if err != nil {
+		log.Fatalf("invalid recipient: %v", err)
+	}
+	return recipient
+}
+
+func joinRecipients(recipients []sops.Recipient) string {
+	keys := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		keys = append(keys, recipient.String())
+	}
+	return strings.Join(keys, ", ")
+}
+
+func init() {
+	rootCmd.AddCommand(keysCmd)
+	keysCmd.AddCommand(keysListCmd)
+	keysCmd.AddCommand(keysAddCmd)
+	keysCmd.AddCommand(keysRemoveCmd)
+	keysCmd.AddCommand(keysRotateDataKeyCmd)
+	for _, cmd := range []*cobra.Command{keysAddCmd, keysRemoveCmd} {
+		cmd.Flags().StringVarP(&recipientType, "type", "t", "", "Type of the recipient, one of age, pgp, kms, gcp_kms, azure_kv or hc_vault, derived from the recipient itself by default")
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"github.com/JamesClonk/plato/pkg/config"
 	"github.com/JamesClonk/plato/pkg/render"
+	"github.com/JamesClonk/plato/pkg/store"
 	"github.com/spf13/cobra"
 )
 
@@ -17,13 +18,17 @@ var renderCmd = &cobra.Command{
 	Long: `Renders all template files from 'plato.source' into 'plato.target',
 and injects all configuration data and secrets from plato.yaml and secrets.yaml.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		config.SetOverrides(overrides)
+		config.SetUsedKeysFunc(render.SourceTemplateKeys)
 		config.InitConfig()
 		render.RenderTemplates(removeTerraformFiles, removeAllDirectories)
+		store.RecordRenderState()
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(renderCmd)
+	addValuesFlags(renderCmd)
 	renderCmd.Flags().BoolVarP(&removeTerraformFiles, "cleanup-terraform", "t", false, "Cleanup all .terraform directories in target path before rendering")
 	renderCmd.Flags().BoolVarP(&removeAllDirectories, "remove-directories", "d", false, "Clean entire target path before rendering")
 }
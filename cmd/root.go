@@ -3,6 +3,7 @@ package cmd
 import (
 	"os"
 
+	"github.com/JamesClonk/plato/pkg/config"
 	"github.com/spf13/cobra"
 )
 
@@ -10,6 +11,8 @@ var (
 	version string
 	commit  string
 	date    string
+
+	overrides config.Overrides
 )
 
 var rootCmd = &cobra.Command{
@@ -30,3 +33,11 @@ func Execute(v, c, d string) {
 		os.Exit(1)
 	}
 }
+
+// addValuesFlags adds the flags for overriding values on the command line to a command
+func addValuesFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVarP(&overrides.ValuesFiles, "values", "f", nil, "Merge additional (optionally SOPS-encrypted) values file, can be specified multiple times")
+	cmd.Flags().StringArrayVar(&overrides.Set, "set", nil, "Set value on the command line, parsed as a YAML scalar, [list] or {map} (e.g. --set image.tag=v1.2.3)")
+	cmd.Flags().StringArrayVar(&overrides.SetString, "set-string", nil, "Set string value on the command line (e.g. --set-string image.tag=1.10)")
+	cmd.Flags().StringArrayVar(&overrides.SetFile, "set-file", nil, "Set value on the command line to the content of a file (e.g. --set-file ca.crt=certs/ca.crt)")
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/render"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/spf13/cobra"
+)
+
+var listRotatable bool
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate <key-glob>...",
+	Short: "Regenerates rotatable secrets",
+	Long: `Regenerates all rotatable secrets whose value path matches any of the given globs, for example "db.*" or "ssh.deploy".
+
+Rotatable are all keypairs generated by SSHKeypair or WireGuardKeypair in the templates, and all secrets declared
+in 'plato.rotate' with a generator spec, for example:
+  rotate:
+    db.password: { type: password, length: 40, charset: alphanumeric }
+    ingress.tls: { type: tls, common_name: example.org, days: 90 }
+
+All templates are rendered again with the new values and the git repository is tainted,
+until "plato store-secrets" stores them into secrets.yaml together with their rotation timestamp under 'plato_metadata.rotated'.
+Rotated secrets must be stored before rotating again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if listRotatable {
+			log.Disable() // output is meant to be processed further
+			config.SetUsedKeysFunc(func() ([][]string, bool) { return nil, false })
+			config.InitConfig()
+			specs, err := render.RotatableSecrets()
+			if err != nil {
+				log.Fatalf("could not determine rotatable secrets: %v", err)
+			}
+			keys := make([]string, 0, len(specs))
+			for key := range specs {
+				keys = append(keys, key)
+			}
+			slices.Sort(keys)
+			for _, key := range keys {
+				fmt.Printf("%s\t%s\n", key, specs[key].Type)
+			}
+			return
+		}
+		if len(args) == 0 {
+			log.Fatalf("no key glob given, see \"plato rotate --list\" for all rotatable secrets")
+		}
+		config.SetOverrides(overrides)
+		config.SetUsedKeysFunc(render.SourceTemplateKeys)
+		config.InitConfig()
+		render.Rotate(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rotateCmd)
+	addValuesFlags(rotateCmd)
+	rotateCmd.Flags().BoolVarP(&listRotatable, "list", "l", false, "List all rotatable secrets and their type")
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/store"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	secretsInPlatoYAML bool
+	rawSecret          bool
+)
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manages secrets in the encrypted SOPS secrets file",
+	Long: `Manages secrets in secrets.yaml, or with --plato in the SOPS-encrypted plato.yaml.
+
+Keys are value paths separated by dots, keys that contain dots themselves have to be escaped as "\.",
+for example: ingress.nginx\.org/ssl.password`,
+}
+
+var secretsListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "Lists the value paths of all secrets",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Disable() // output is meant to be processed further
+		paths, err := store.ListSecrets(initSecretsFile())
+		if err != nil {
+			log.Fatalf("could not list secrets: %v", err)
+		}
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+	},
+}
+
+var secretsGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Shows a decrypted secret",
+	Long:  `Shows a decrypted secret as YAML, or with --raw strings as they are, like generated secrets files would contain them.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Disable() // output is meant to be processed further
+		order := ordered.NewOrder()
+		value, err := store.GetSecret(initSecretsFile(), args[0], order)
+		if err != nil {
+			log.Fatalf("could not get secret: %v", err)
+		}
+		if s, ok := value.(string); ok && rawSecret {
+			fmt.Print(s)
+			return
+		}
+		node, err := ordered.ToNode(value, order)
+		if err != nil {
+			log.Fatalf("could not encode secret: %v", err)
+		}
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(node); err != nil {
+			log.Fatalf("could not encode secret: %v", err)
+		}
+	},
+}
+
+var secretsSetCmd = &cobra.Command{
+	Use:   "set <key> [file]",
+	Short: "Stores a secret read from a file or STDIN",
+	Long: `Stores a secret read from a file, or from STDIN if no file or "-" is given.
+The value is stored the same way store-secrets does, binary content base64-encoded and .yaml/.json files
+as subtrees if 'plato.secrets_structured' is enabled.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		filename := initSecretsFile()
+		source, reader := "-", io.Reader(os.Stdin)
+		if len(args) > 1 && args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				log.Fatalf("could not read [%s]: %v", color.Magenta(args[1]), err)
+			}
+			defer f.Close()
+			source, reader = args[1], f
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			log.Fatalf("could not read [%s]: %v", color.Magenta(source), err)
+		}
+		if err := store.SetSecret(filename, args[0], source, data); err != nil {
+			log.Fatalf("could not store secret [%s]: %v", color.Magenta(args[0]), err)
+		}
+		log.Infof("stored secret [%s] in [%s]", color.Magenta(args[0]), color.Magenta(filename))
+	},
+}
+
+var secretsRemoveCmd = &cobra.Command{
+	Use:     "rm <key>",
+	Aliases: []string{"remove", "delete"},
+	Short:   "Removes a secret and everything below it",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		filename := initSecretsFile()
+		if err := store.RemoveSecret(filename, args[0]); err != nil {
+			log.Fatalf("could not remove secret [%s]: %v", color.Magenta(args[0]), err)
+		}
+		log.Infof("removed secret [%s] from [%s]", color.Magenta(args[0]), color.Magenta(filename))
+	},
+}
+
+var secretsEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Edits the decrypted secrets in $EDITOR",
+	Long: `Opens the decrypted secrets in $EDITOR, default is vi. The decrypted file is written to a private temporary
+directory on tmpfs (/dev/shm or $XDG_RUNTIME_DIR) if available, and removed right afterwards.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		filename := initSecretsFile()
+		if err := store.EditSecrets(filename); err != nil {
+			log.Fatalf("could not edit [%s]: %v", color.Magenta(filename), err)
+		}
+	},
+}
+
+// initSecretsFile loads the configuration and returns the encrypted file to work on
+func initSecretsFile() string {
+	// none of the secrets are needed for rendering, so there is no need to decrypt them while loading
+	config.SetUsedKeysFunc(func() ([][]string, bool) { return nil, false })
+	config.InitConfig()
+
+	filename := config.SecretsFile()
+	if secretsInPlatoYAML {
+		filename = viper.ConfigFileUsed()
+	}
+	if !sops.IsEncryptedFile(filename) {
+		log.Fatalf("[%s] does not exist or is not SOPS-encrypted", color.Magenta(filename))
+	}
+	return filename
+}
+
+func init() {
+	rootCmd.AddCommand(secretsCmd)
+	secretsCmd.PersistentFlags().BoolVar(&secretsInPlatoYAML, "plato", false, "Work on the SOPS-encrypted plato.yaml instead of secrets.yaml")
+	secretsGetCmd.Flags().BoolVarP(&rawSecret, "raw", "r", false, "Show strings as they are instead of as YAML")
+	secretsCmd.AddCommand(secretsListCmd, secretsGetCmd, secretsSetCmd, secretsRemoveCmd, secretsEditCmd)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/store"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows pending secrets and stale renders",
+	Long: `Shows generated secrets not yet stored in secrets.yaml, templates modified since they have been rendered,
+rendered *.sops_enc files that differ from their encrypted source, and if the git repository is tainted.
+
+The exit code is meant for pre-commit hooks, 0 if everything is up to date, otherwise a combination of:
+  1: the .secrets-updated marker file exists, the git repository is tainted
+  2: generated secrets differ from secrets.yaml
+  4: templates, plato.yaml or secrets.yaml have been modified since the last render
+  8: rendered *.sops_enc files differ from their encrypted source
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config.InitConfig()
+		status := store.GetStatus()
+
+		if status.Tainted {
+			log.Warnf("git repository is tainted, [%s] marker file exists", color.Magenta(".secrets-updated"))
+			for _, reason := range status.TaintReasons {
+				log.Warnf("  %s", reason)
+			}
+		}
+		for _, key := range status.PendingSecrets {
+			log.Warnf("pending secret [%s], not stored in secrets.yaml yet", color.Magenta(key))
+		}
+		for _, path := range status.StaleRenders {
+			log.Warnf("stale render, [%s] has not been rendered since it was modified", color.Magenta(path))
+		}
+		for _, path := range status.OutdatedFiles {
+			log.Warnf("out-of-date [%s], its rendered copy differs", color.Magenta(path))
+		}
+
+		code := status.ExitCode()
+		if code == 0 {
+			log.Infof("everything is up to date")
+		}
+		os.Exit(code)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
@@ -6,23 +6,43 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var storeOptions store.Options
+
 var storeCmd = &cobra.Command{
 	Aliases: []string{"save", "store"},
 	Use:     "store-secrets",
 	Short:   "Stores generated secrets in encrypted SOPS file",
 	Long: `Stores all dynamically generated secrets found under 'plato.secrets' back into the encrypted SOPS secret file. Property paths are determined by naming convention.
 
-By convention the filenames under 'plato.secrets'/* will translate into YAML paths, for example:
-./rendered/secrets/tls.key -> "tls.key:" in secrets.yaml
+By convention the files under 'plato.secrets'/* will translate into YAML paths, subdirectories becoming nested keys
+and filenames being split on 'plato.secrets_separator' (default "."), for example:
+./rendered/secrets/tls.key -> "tls: key:" in secrets.yaml
+./rendered/secrets/prod/db/password -> "prod: db: password:" in secrets.yaml
+
+Exceptions can be mapped explicitly to value paths with 'plato.secrets_map'.
+
+It will also re-encrypt all formerly named *.sops_enc files back to their original location under 'plato.source',
+and store all secrets rotated by "plato rotate" together with their rotation timestamp.
+
+With --prune all keys below 'plato.secrets_namespace' whose file no longer exists are removed.
+
+Before anything is written a summary of all changes is shown, with value lengths and short hashes instead of plaintext,
+which has to be confirmed interactively or with --yes. With --dry-run only the summary is shown.
 
-It will also re-encrypt all formerly named *.sops_enc files back to their original location under 'plato.source'.
+Secrets and files changed both in their rendered copy and their source since the last render (for example because
+new secrets have been pulled meanwhile) are conflicts, which have to be resolved with either --ours or --theirs.
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
 		config.InitConfig()
-		store.StoreGeneratedSecrets()
+		store.StoreGeneratedSecrets(storeOptions)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(storeCmd)
+	storeCmd.Flags().BoolVar(&storeOptions.Prune, "prune", false, "Remove keys below 'plato.secrets_namespace' from secrets.yaml whose file no longer exists")
+	storeCmd.Flags().BoolVarP(&storeOptions.DryRun, "dry-run", "n", false, "Only show which secrets would be stored, without writing anything")
+	storeCmd.Flags().BoolVarP(&storeOptions.Yes, "yes", "y", false, "Store secrets without asking for confirmation")
+	storeCmd.Flags().BoolVar(&storeOptions.Ours, "ours", false, "Resolve conflicts by storing the rendered copies")
+	storeCmd.Flags().BoolVar(&storeOptions.Theirs, "theirs", false, "Resolve conflicts by keeping the sources")
 }
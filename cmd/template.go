@@ -35,6 +35,10 @@ and injects all configuration data and secrets from plato.yaml and (optional) se
 			log.Disable() // disable all log output if we render to STDOUT
 		}
 
+		config.SetOverrides(overrides)
+		config.SetUsedKeysFunc(func() ([][]string, bool) {
+			return render.TemplateKeys(inputFile)
+		})
 		config.InitConfig()
 		render.RenderFile(inputFile, outputFile)
 	},
@@ -42,4 +46,5 @@ and injects all configuration data and secrets from plato.yaml and (optional) se
 
 func init() {
 	rootCmd.AddCommand(templateCmd)
+	addValuesFlags(templateCmd)
 }
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"unicode/utf8"
+)
+
+// binaryMarker prefixes base64-encoded binary values in secrets.yaml, like keystores stored by "plato store-secrets".
+// LoadSecrets decodes them transparently, so templates get the original bytes
+const binaryMarker = "!!binary "
+
+// IsBinary checks if data can't be stored as a plain string, because it is not valid UTF-8 or contains NUL bytes
+func IsBinary(data []byte) bool {
+	return !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0
+}
+
+// EncodeBinary returns data base64-encoded and marked as binary
+func EncodeBinary(data []byte) string {
+	return binaryMarker + base64.StdEncoding.EncodeToString(data)
+}
+
+// decodeBinary replaces all values marked as binary with their decoded content
+func decodeBinary(m map[string]any) {
+	for key, value := range m {
+		m[key] = DecodeBinaryValue(value)
+	}
+}
+
+// DecodeBinaryValue returns a value with its binary-marked content decoded, including within maps and lists
+func DecodeBinaryValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		if !strings.HasPrefix(v, binaryMarker) {
+			return v
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v, binaryMarker))
+		if err != nil {
+			return v // not ours, keep it as it is
+		}
+		return string(data)
+	case map[string]any:
+		decodeBinary(v)
+	case []any:
+		for i, item := range v {
+			v[i] = DecodeBinaryValue(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Binary(t *testing.T) {
+	assert.False(t, IsBinary([]byte("multi\nline\ttext with ünïcödé\n")))
+	assert.True(t, IsBinary([]byte{0xfe, 0xed, 0xfe, 0xed}))
+	assert.True(t, IsBinary([]byte("nul\x00byte")))
+
+	encoded := EncodeBinary([]byte{0xfe, 0xed, 0x00, 0x02})
+	assert.Equal(t, "!!binary /u0AAg==", encoded)
+
+	m := map[string]any{
+		"keystore": encoded,
+		"nested":   map[string]any{"list": []any{encoded, "plain"}},
+		"invalid":  "!!binary not-base64",
+	}
+	decodeBinary(m)
+	assert.Equal(t, "\xfe\xed\x00\x02", m["keystore"])
+	assert.Equal(t, []any{"\xfe\xed\x00\x02", "plain"}, m["nested"].(map[string]any)["list"])
+	assert.Equal(t, "!!binary not-base64", m["invalid"])
+}
@@ -1,17 +1,19 @@
 package config
 
 import (
+	"bytes"
 	"os"
 	"os/user"
 	"path"
 	"path/filepath"
 	"strings"
 
+	"github.com/JamesClonk/plato/pkg/sops"
 	"github.com/JamesClonk/plato/pkg/util/color"
-	"github.com/JamesClonk/plato/pkg/util/command"
 	"github.com/JamesClonk/plato/pkg/util/file"
 	"github.com/JamesClonk/plato/pkg/util/log"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 // initConfig reads in config file and ENV variables if set
@@ -83,10 +85,42 @@ func InitConfig() {
 	} else { // fail if no plato.yaml was found, plato insists on it!
 		log.Fatalf("could not read configuration file: %s", color.Red("%v", err))
 	}
+	// viper only keeps the plato settings, all other values are loaded by LoadSecrets
+	if !FoldKeys() {
+		data, err := yaml.Marshal(map[string]any{"plato": viper.AllSettings()["plato"]})
+		if err == nil {
+			err = viper.ReadConfig(bytes.NewReader(data))
+		}
+		if err != nil {
+			log.Fatalf("could not read configuration file: %s", color.Red("%v", err))
+		}
+	}
+
+	// pick the SOPS implementation, the sops binary or the native age backend
+	if sops.IsEncryptedValue(SOPSBackend()) {
+		log.Fatalf("'plato.sops_backend' must not be encrypted, it is needed to decrypt [%s]", color.Magenta(viper.ConfigFileUsed()))
+	}
+	backend, err := sops.New(SOPSBackend())
+	if err != nil {
+		log.Fatalf("invalid 'plato.sops_backend': %s", color.Red("%v", err))
+	}
+	sops.SetBackend(backend)
 
 	// decrypt and/or load secrets
 	LoadSecrets()
 
+	// values files and --set overrides from the command line take precedence over everything else
+	applyOverrides()
+
+	// never render with values that are still encrypted
+	checkEncrypted()
+
+	// resolve all "ref+" value references, like ref+env://IMAGE_TAG or ref+sops://other/secrets.yaml#/db/password
+	resolveReferences()
+
+	// read Terraform state and other configured datasources
+	loadDatasources()
+
 	// properly re-initialize logger again, we now have the correct intended configuration values available
 	log.Initialize()
 
@@ -96,13 +130,21 @@ func InitConfig() {
 // load secrets into config
 func LoadSecrets() {
 	requireSecretsYAML := true
+	values = make(map[string]any)
+	usedKeys, usedPaths = nil, nil
 
-	// check first if the loaded plato configuration file itself actually is SOPS-encrypted
-	if viper.IsSet("sops.version") && viper.IsSet("sops.mac") && viper.IsSet("sops.age") {
+	// check first if the loaded plato configuration file itself actually is SOPS-encrypted, with any kind of key.
+	// it is always decrypted entirely and before anything else, since the plato settings might be encrypted too
+	if sops.IsEncryptedFile(viper.ConfigFileUsed()) {
 		requireSecretsYAML = false // we don't require an additional secrets file in this case
 		loadSecrets(viper.ConfigFileUsed())
+	} else if len(viper.ConfigFileUsed()) > 0 {
+		loadValues(viper.ConfigFileUsed())
 	}
 
+	// find out which values the templates need, to avoid decrypting secrets nobody uses
+	determineUsedKeys()
+
 	pwd, err := os.Getwd()
 	if err != nil {
 		log.Fatalf("could not read current working directory: %s", color.Red("%v", err))
@@ -119,20 +161,55 @@ func LoadSecrets() {
 		}
 		return
 	}
+	if !needsDecryption(secretsFile) {
+		log.Infof("skipping decryption of [%s], none of its encrypted values are used", color.Magenta(secretsFile))
+		loadUnencrypted(secretsFile)
+		return
+	}
 	loadSecrets(secretsFile)
 }
 
 func loadSecrets(inputFile string) {
 	// decrypt file
-	decryptedSecrets, err := command.ExecOutput([]string{"sops", "-d", inputFile})
-	if err != nil {
-		log.Errorf("could not decrypt [%s] with SOPS: %s", color.Magenta(inputFile), color.Red("%v", err))
-		return
+	decryptedSecrets, err := sops.Decrypt(inputFile)
+	if err != nil { // fail, rendering with encrypted values would only produce garbage
+		log.Fatalf("could not decrypt [%s] with SOPS: %s", color.Magenta(inputFile), color.Red("%v", err))
 	}
-	// read in decrypted secrets
-	if err := viper.MergeConfig(strings.NewReader(decryptedSecrets)); err == nil {
+	// read in decrypted secrets, without any values the templates don't need
+	if err := mergeSettings(decryptedSecrets, filterUsed, decodeBinary); err == nil {
 		log.Infof("loaded secrets from [%s]", color.Magenta(inputFile))
 	} else { // fail if no secrets.yaml was found, plato insists on it!
 		log.Fatalf("could not load secrets from [%s]: %s", color.Magenta(inputFile), color.Red("%v", err))
 	}
+	if err := mergeValues(decryptedSecrets, inputFile, filterUsed, decodeBinary); err != nil {
+		log.Fatalf("could not load secrets from [%s]: %s", color.Magenta(inputFile), color.Red("%v", err))
+	}
+}
+
+// loadUnencrypted loads only the plaintext values of a partially SOPS-encrypted file, without decrypting it
+func loadUnencrypted(inputFile string) {
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		log.Fatalf("could not read [%s]: %s", color.Magenta(inputFile), color.Red("%v", err))
+	}
+	if err := mergeSettings(data, withoutEncrypted, filterUsed, decodeBinary); err != nil {
+		log.Fatalf("could not load [%s]: %s", color.Magenta(inputFile), color.Red("%v", err))
+	}
+	if err := mergeValues(data, inputFile, withoutEncrypted, filterUsed, decodeBinary); err != nil {
+		log.Fatalf("could not load [%s]: %s", color.Magenta(inputFile), color.Red("%v", err))
+	}
+}
+
+// checkEncrypted fails if any value used by the templates is still encrypted,
+// for example because it was copied from a SOPS-encrypted file without its metadata
+func checkEncrypted() {
+	settings := Values()
+	for _, key := range order.Keys(settings) {
+		if key == "sops" {
+			continue // the metadata of an encrypted plato.yaml, its MAC is always encrypted
+		}
+		if isUsed(key) && sops.ContainsEncrypted(settings[key]) {
+			log.Fatalf("value [%s] is still SOPS-encrypted, its file is missing the SOPS metadata or could not be decrypted", color.Red(key))
+		}
+	}
 }
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/plato/pkg/datasource"
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/file"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"github.com/spf13/cast"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	execTimeout       = 30 * time.Second
+	vaultMount        = "secret"
+	vaultPrefix       = "vault"
+	vaultAppRoleMount = "approle"
+)
+
+// loadDatasources reads all configured datasources and adds their data to the template payload
+func loadDatasources() {
+	if err := loadTerraformStates(); err != nil {
+		log.Fatalf("could not load Terraform state: %s", color.Red("%v", err))
+	}
+	if err := loadVault(); err != nil {
+		log.Fatalf("could not load secrets from Vault: %s", color.Red("%v", err))
+	}
+	if err := loadExecDatasources(); err != nil {
+		log.Fatalf("could not load exec datasource: %s", color.Red("%v", err))
+	}
+}
+
+// loadTerraformStates reads all Terraform state files configured under 'plato.tfstate' and exposes them as '.tfstate.<name>'.
+// Each entry is either just the path to the state file, or a map with "path" and "resources: true" to include resources too
+func loadTerraformStates() error {
+	value, ok := setting("tfstate")
+	if !ok || value == nil {
+		return nil
+	}
+	entries, ok := value.(map[string]any)
+	if !ok {
+		return fmt.Errorf("'plato.tfstate' must be a map of names to state files")
+	}
+
+	states := make(map[string]any)
+	keys := order.Keys(entries)
+	for _, name := range keys {
+		var path string
+		var resources bool
+		switch entry := entries[name].(type) {
+		case string:
+			path = entry
+		case map[string]any:
+			path, _ = entry["path"].(string)
+			resources, _ = entry["resources"].(bool)
+		}
+		if len(path) == 0 {
+			return fmt.Errorf("no path configured for 'plato.tfstate.%s'", name)
+		}
+
+		state, err := datasource.TerraformState(path, resources, order)
+		if err != nil {
+			return err
+		}
+		log.Infof("loaded Terraform state [%s] from [%s]", color.Magenta(name), color.Magenta(path))
+		states[name] = state
+	}
+	order.Remember(states, keys)
+	Set("tfstate", states)
+	return nil
+}
+
+// loadVault reads all KV version 2 secrets listed in 'plato.vault.paths' and merges them under 'plato.vault.prefix',
+// for example the secret at "apps/web" becomes available as '.vault.apps.web'.
+// The token is taken from VAULT_TOKEN, or obtained by an AppRole login with the role_id and secret_id from 'plato.vault.approle_file'.
+// If 'plato.vault.cache' is set all secrets are also written SOPS-encrypted to that file, to be used instead of Vault with 'plato.vault.offline'
+func loadVault() error {
+	paths := settingStrings("vault.paths")
+	if len(paths) == 0 {
+		return nil
+	}
+
+	var secrets map[string]any
+	var err error
+	if settingBool("vault.offline", false) {
+		if secrets, err = readVaultCache(); err != nil {
+			return err
+		}
+		log.Infof("loaded Vault secrets from cache [%s]", color.Magenta(settingString("vault.cache")))
+	} else {
+		if secrets, err = readVault(paths); err != nil {
+			return err
+		}
+		if err := writeVaultCache(secrets); err != nil {
+			return err
+		}
+	}
+
+	prefix := vaultPrefix
+	if value, ok := setting("vault.prefix"); ok && value != nil {
+		prefix = cast.ToString(value)
+	}
+	for _, path := range paths {
+		secret, ok := secrets[path].(map[string]any)
+		if !ok {
+			return fmt.Errorf("no secret found for [%s]", path)
+		}
+		target := strings.ReplaceAll(strings.ReplaceAll(strings.Trim(path, "/"), ".", "\\."), "/", ".")
+		if len(prefix) > 0 {
+			target = prefix + "." + target
+		}
+		mergeAt(target, secret)
+	}
+	return nil
+}
+
+func readVault(paths []string) (map[string]any, error) {
+	address := os.Getenv("VAULT_ADDR")
+	if len(settingString("vault.address")) > 0 {
+		address = settingString("vault.address")
+	}
+	if len(address) == 0 {
+		return nil, fmt.Errorf("no Vault address configured, set 'plato.vault.address' or VAULT_ADDR")
+	}
+	mount := vaultMount
+	if len(settingString("vault.mount")) > 0 {
+		mount = settingString("vault.mount")
+	}
+
+	client := datasource.NewVaultClient(address, settingString("vault.namespace"), os.Getenv("VAULT_TOKEN"))
+	if approleFile := settingString("vault.approle_file"); len(approleFile) > 0 {
+		data, err := os.ReadFile(approleFile)
+		if err != nil {
+			return nil, err
+		}
+		var approle struct {
+			RoleID   string `yaml:"role_id"`
+			SecretID string `yaml:"secret_id"`
+		}
+		if err := yaml.Unmarshal(data, &approle); err != nil {
+			return nil, fmt.Errorf("could not parse [%s]: %v", approleFile, err)
+		}
+		approleMount := vaultAppRoleMount
+		if len(settingString("vault.approle_mount")) > 0 {
+			approleMount = settingString("vault.approle_mount")
+		}
+		if err := client.AppRoleLogin(approleMount, approle.RoleID, approle.SecretID); err != nil {
+			return nil, err
+		}
+	}
+	if len(client.Token) == 0 {
+		return nil, fmt.Errorf("no Vault token available, set VAULT_TOKEN or 'plato.vault.approle_file'")
+	}
+
+	secrets := make(map[string]any)
+	for _, path := range paths {
+		secret, err := client.ReadKV(mount, path, order)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("loaded Vault secret [%s]", color.Magenta(mount+"/"+path))
+		secrets[path] = secret
+	}
+	order.Remember(secrets, paths)
+	return secrets, nil
+}
+
+func readVaultCache() (map[string]any, error) {
+	cacheFile := settingString("vault.cache")
+	if len(cacheFile) == 0 {
+		return nil, fmt.Errorf("offline mode requires 'plato.vault.cache' to be set")
+	}
+	if !file.Exists(cacheFile) {
+		return nil, fmt.Errorf("Vault cache [%s] does not exist, run once without 'plato.vault.offline' to create it", cacheFile)
+	}
+	data, err := sops.Decrypt(cacheFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt Vault cache [%s] with SOPS, run once without 'plato.vault.offline' to recreate it: %v", cacheFile, err)
+	}
+	document, err := ordered.Unmarshal(data, order)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse Vault cache [%s]: %v", cacheFile, err)
+	}
+	secrets, ok := document.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("could not parse Vault cache [%s]: top-level value must be a map", cacheFile)
+	}
+	return secrets, nil
+}
+
+// writeVaultCache stores all Vault secrets encrypted with SOPS, same as secrets.yaml. The file needs a creation rule in .sops.yaml,
+// the secrets are never written to disk in plaintext
+func writeVaultCache(secrets map[string]any) error {
+	cacheFile := settingString("vault.cache")
+	if len(cacheFile) == 0 {
+		return nil
+	}
+	if sops.Format(cacheFile) != "yaml" {
+		return fmt.Errorf("Vault cache [%s] must be a .yaml file", cacheFile)
+	}
+	data, err := yaml.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	encrypted, err := sops.Encrypt(cacheFile, data)
+	if err != nil {
+		return fmt.Errorf("could not encrypt Vault cache [%s] with SOPS: %v", cacheFile, err)
+	}
+	if err := os.WriteFile(cacheFile, encrypted, 0600); err != nil {
+		return fmt.Errorf("could not write Vault cache: %v", err)
+	}
+	return nil
+}
+
+// mergeAt deep-merges a map into the template payload at the given path
+func mergeAt(path string, m map[string]any) {
+	if existing, ok := Lookup(path); ok && !FoldKeys() {
+		if existingMap, ok := existing.(map[string]any); ok {
+			Merge(existingMap, m)
+			return
+		}
+	}
+	Set(path, m)
+}
+
+// loadExecDatasources runs all commands listed in 'plato.exec' and stores their parsed output under the given value path:
+//
+//	exec:
+//	- path: registry.password
+//	  command: [pass, show, registry]
+//	  format: raw # or yaml, json
+//	  timeout: 10s
+//	  env: [GPG_TTY, PASSWORD_STORE_DIR]
+//
+// Same as for "ref+exec://" references, each command must be listed in 'plato.ref_exec_allowlist'
+func loadExecDatasources() error {
+	value, ok := setting("exec")
+	if !ok || value == nil {
+		return nil
+	}
+	entries, ok := value.([]any)
+	if !ok {
+		return fmt.Errorf("'plato.exec' must be a list of datasources")
+	}
+
+	for i, e := range entries {
+		entry, ok := e.(map[string]any)
+		if !ok {
+			return fmt.Errorf("'plato.exec[%d]' must be a map", i)
+		}
+		path, _ := entry["path"].(string)
+		if len(path) == 0 {
+			return fmt.Errorf("no path configured for 'plato.exec[%d]'", i)
+		}
+
+		var cmd []string
+		switch c := entry["command"].(type) {
+		case string:
+			cmd = strings.Fields(c)
+		case []any:
+			for _, arg := range c {
+				cmd = append(cmd, fmt.Sprintf("%v", arg))
+			}
+		}
+		if len(cmd) == 0 {
+			return fmt.Errorf("no command configured for [%s]", path)
+		}
+		if !slices.Contains(RefExecAllowlist(), cmd[0]) {
+			return fmt.Errorf("command [%s] for [%s] is not listed in 'plato.ref_exec_allowlist'", cmd[0], path)
+		}
+		format, _ := entry["format"].(string)
+		timeout := execTimeout
+		if t, ok := entry["timeout"].(string); ok {
+			var err error
+			if timeout, err = time.ParseDuration(t); err != nil {
+				return fmt.Errorf("invalid timeout for [%s]: %v", path, err)
+			}
+		}
+		env := make([]string, 0)
+		if list, ok := entry["env"].([]any); ok {
+			for _, name := range list {
+				env = append(env, fmt.Sprintf("%v", name))
+			}
+		}
+
+		value, err := datasource.Exec(cmd, format, timeout, env, order)
+		if err != nil {
+			return fmt.Errorf("[%s]: %v", path, err)
+		}
+		log.Infof("loaded exec datasource [%s]", color.Magenta(path))
+		if m, ok := value.(map[string]any); ok {
+			mergeAt(path, m)
+			continue
+		}
+		Set(path, value)
+	}
+	return nil
+}
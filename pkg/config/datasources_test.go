@@ -0,0 +1,119 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_loadVault(t *testing.T) {
+	values = make(map[string]any)
+	t.Cleanup(func() {
+		values = make(map[string]any)
+		Set("plato.vault", nil)
+	})
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "root", r.Header.Get("X-Vault-Token"))
+		switch r.URL.Path {
+		case "/v1/kv/data/apps/web":
+			_, _ = w.Write([]byte(`{"data":{"data":{"password":"s3cr3t"}}}`))
+		case "/v1/kv/data/shared/example.com":
+			_, _ = w.Write([]byte(`{"data":{"data":{"api_key":"abc"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	defer sops.SetBackend(sops.GetBackend())
+	fake := sops.NewFake()
+	sops.SetBackend(fake)
+
+	cacheFile := filepath.Join(t.TempDir(), "vault-cache.yaml")
+	t.Setenv("VAULT_TOKEN", "root")
+	Set("plato.vault", map[string]any{
+		"address": server.URL,
+		"mount":   "kv",
+		"paths":   []string{"apps/web", "shared/example.com"},
+		"cache":   cacheFile,
+	})
+	assert.NoError(t, mergeValues([]byte("vault:\n  apps:\n    web:\n      username: web\n"), "plato.yaml"))
+
+	assert.NoError(t, loadVault())
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, "web", LookupString("vault.apps.web.username"))
+	assert.Equal(t, "s3cr3t", LookupString("vault.apps.web.password"))
+	assert.Equal(t, "abc", LookupString(`vault.shared.example\.com.api_key`))
+
+	// the cache is only written encrypted
+	info, err := os.Stat(cacheFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := os.ReadFile(cacheFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "s3cr3t")
+	assert.Contains(t, string(fake.Files[cacheFile]), "s3cr3t")
+
+	// offline mode must only use the cache
+	values = make(map[string]any)
+	Set("plato.vault.offline", true)
+	Set("plato.vault.prefix", "secrets.vault")
+	assert.NoError(t, loadVault())
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, "s3cr3t", LookupString("secrets.vault.apps.web.password"))
+
+	Set("plato.vault.cache", filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, loadVault())
+
+	// plaintext caches are not read
+	plaintext := filepath.Join(t.TempDir(), "plaintext.yaml")
+	assert.NoError(t, os.WriteFile(plaintext, []byte("apps/web:\n  password: s3cr3t\n"), 0600))
+	Set("plato.vault.cache", plaintext)
+	assert.Error(t, loadVault())
+}
+
+func Test_loadExecDatasources(t *testing.T) {
+	values = make(map[string]any)
+	t.Cleanup(func() {
+		values = make(map[string]any)
+		Set("plato.exec", nil)
+		Set("plato.ref_exec_allowlist", nil)
+	})
+
+	assert.NoError(t, mergeValues([]byte(`
+plato:
+  ref_exec_allowlist: [echo, sh, "false", "true"]
+  exec:
+  - path: registry.password
+    command: echo s3cr3t
+  - path: app
+    command: [sh, -c, "echo '{\"token\": \"abc\"}'"]
+    format: json
+    timeout: 5s
+registry:
+  username: registry-user
+app:
+  name: example
+`), "plato.yaml"))
+
+	assert.NoError(t, loadExecDatasources())
+	assert.Equal(t, "registry-user", LookupString("registry.username"))
+	assert.Equal(t, "s3cr3t", LookupString("registry.password"))
+	assert.Equal(t, "example", LookupString("app.name"))
+	assert.Equal(t, "abc", LookupString("app.token"))
+
+	Set("plato.exec", []any{map[string]any{"path": "broken", "command": "false"}})
+	assert.Error(t, loadExecDatasources())
+	Set("plato.exec", []any{map[string]any{"path": "broken", "command": "true", "timeout": "soon"}})
+	assert.ErrorContains(t, loadExecDatasources(), "invalid timeout")
+	Set("plato.exec", []any{map[string]any{"path": "broken", "command": "cat /etc/hostname"}})
+	assert.ErrorContains(t, loadExecDatasources(), "not listed in 'plato.ref_exec_allowlist'")
+}
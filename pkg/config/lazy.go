@@ -0,0 +1,132 @@
+package config
+
+import (
+	"os"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+)
+
+var lazySecrets = true
+
+// UsedKeysFunc returns the value paths used by the templates about to be rendered,
+// or all=true if that can't be determined
+type UsedKeysFunc func() (paths [][]string, all bool)
+
+var (
+	usedKeysFunc UsedKeysFunc
+	usedKeys     map[string]bool // top-level keys of usedPaths, nil means all keys are used
+	usedPaths    [][]string
+)
+
+// SetUsedKeysFunc registers the function InitConfig uses to find out which values the templates need,
+// so that secrets files none of whose values are used don't have to be decrypted at all
+func SetUsedKeysFunc(f UsedKeysFunc) {
+	usedKeysFunc = f
+}
+
+// LazySecrets determines if secrets are only decrypted if the templates actually use them
+func LazySecrets() bool {
+	return settingBool("lazy_secrets", lazySecrets)
+}
+
+// determineUsedKeys asks the registered UsedKeysFunc for all value paths needed for rendering
+func determineUsedKeys() {
+	usedKeys, usedPaths = nil, nil
+	if usedKeysFunc == nil || !LazySecrets() {
+		return
+	}
+	paths, all := usedKeysFunc()
+	if all {
+		return
+	}
+	usedKeys = map[string]bool{"plato": true} // plato settings are always needed
+	usedPaths = [][]string{{"plato"}}
+	for _, path := range paths {
+		if len(path) > 0 {
+			usedKeys[path[0]] = true
+			usedPaths = append(usedPaths, path)
+		}
+	}
+}
+
+// isUsed checks if a top-level value key is used by the templates
+func isUsed(key string) bool {
+	return usedKeys == nil || usedKeys[key]
+}
+
+// Loaded checks if the values below a top-level key have been loaded, secrets the templates don't use are left out
+func Loaded(key string) bool {
+	return isUsed(key)
+}
+
+// filterUsed removes all top-level keys not used by the templates
+func filterUsed(m map[string]any) {
+	for key := range m {
+		if !isUsed(key) {
+			delete(m, key)
+		}
+	}
+}
+
+// needsDecryption checks if any of the used value paths leads to an encrypted value within a SOPS-encrypted YAML file.
+// Only values are encrypted by SOPS, and partially encrypted files ('unencrypted_suffix', 'encrypted_regex', etc.)
+// keep some of them in plaintext, so this works without having to decrypt the file
+func needsDecryption(inputFile string) bool {
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return true // let decryption fail and report the error
+	}
+	document, err := ordered.Unmarshal(data, nil)
+	if err != nil {
+		return true
+	}
+	m, ok := document.(map[string]any)
+	if !ok {
+		return true
+	}
+	delete(m, "sops")
+	if usedKeys == nil {
+		return sops.ContainsEncrypted(m)
+	}
+	for _, path := range usedPaths {
+		if usesEncrypted(m, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// usesEncrypted checks if a value path leads to an encrypted value in nested maps,
+// or into a value that isn't a map but contains encrypted values
+func usesEncrypted(object any, path []string) bool {
+	m, ok := object.(map[string]any)
+	if len(path) == 0 || !ok {
+		return sops.ContainsEncrypted(object) // for lists or strings the template could use anything within it
+	}
+	// try longest key first, same as Lookup does
+	for i := len(path); i > 0; i-- {
+		if value, exists := m[strings.Join(path[:i], ".")]; exists && usesEncrypted(value, path[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutEncrypted removes the SOPS metadata and all values containing anything encrypted,
+// leaving only the plaintext values of a partially encrypted file
+func withoutEncrypted(m map[string]any) {
+	delete(m, "sops")
+	dropEncrypted(m)
+}
+
+func dropEncrypted(m map[string]any) {
+	for key, value := range m {
+		if nested, ok := value.(map[string]any); ok {
+			dropEncrypted(nested)
+		} else if sops.ContainsEncrypted(value) {
+			delete(m, key)
+		}
+	}
+}
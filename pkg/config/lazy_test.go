@@ -0,0 +1,121 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_needsDecryption(t *testing.T) {
+	t.Cleanup(func() {
+		usedKeysFunc = nil
+		determineUsedKeys()
+	})
+	secretsFile := "../../_fixtures/secrets.yaml"
+
+	for _, tc := range []struct {
+		paths    [][]string
+		all      bool
+		decrypt  bool
+	}{
+		{paths: [][]string{{"registry", "hostname"}, {"cidr"}}, decrypt: false},
+		{paths: [][]string{{"registry", "password"}}, decrypt: true},
+		{paths: [][]string{{"registry"}}, decrypt: true},
+		{paths: [][]string{{"ssh", "private_key", "anything"}}, decrypt: true}, // within a string value
+		{paths: [][]string{{"sops"}}, decrypt: false},
+		{paths: nil, decrypt: false},
+		{all: true, decrypt: true},
+	} {
+		SetUsedKeysFunc(func() ([][]string, bool) { return tc.paths, tc.all })
+		determineUsedKeys()
+		assert.Equal(t, tc.decrypt, needsDecryption(secretsFile), tc.paths)
+	}
+
+	// without a UsedKeysFunc everything is used
+	usedKeysFunc = nil
+	determineUsedKeys()
+	assert.True(t, needsDecryption(secretsFile))
+	assert.True(t, isUsed("anything"))
+}
+
+func Test_filterUsed(t *testing.T) {
+	t.Cleanup(func() {
+		usedKeysFunc = nil
+		determineUsedKeys()
+	})
+	SetUsedKeysFunc(func() ([][]string, bool) { return [][]string{{"ssh", "public_key"}}, false })
+	determineUsedKeys()
+
+	m := map[string]any{"plato": map[string]any{}, "ssh": "key", "registry": "password"}
+	filterUsed(m)
+	assert.Equal(t, map[string]any{"plato": map[string]any{}, "ssh": "key"}, m)
+}
+
+func Test_needsDecryption_partial(t *testing.T) {
+	t.Cleanup(func() {
+		usedKeysFunc = nil
+		determineUsedKeys()
+	})
+	// encrypted with 'encrypted_regex: ^password$', everything else is plaintext
+	secretsFile := filepath.Join(t.TempDir(), "secrets.yaml")
+	assert.NoError(t, os.WriteFile(secretsFile, []byte(`registry:
+  hostname: registry.example.com
+  password: ENC[AES256_GCM,data:3C/nwQBGppSlEoD/HLaWAYMjVcWkAbsBRlpl,iv:jT0IjO9WBInejU4ldx67esGkE8gAX3yZPTvQoOdoQ/M=,tag:heQw5YLBOjxklVLeFJFTeQ==,type:str]
+hosts:
+  - one
+  - ENC[AES256_GCM,data:9uYY77Qeoa8dXT2h6Q==,iv:q+1Off8Cw7+eLQMinmEKY5cXrnTyQ28vfbafvH5XcVs=,tag:ZH4H/ekibHAKygMY+r7nDA==,type:str]
+sops:
+  mac: ENC[AES256_GCM,data:Q06V,iv:PjLg,tag:9TBh,type:str]
+  encrypted_regex: ^password$
+  version: 3.10.2
+`), 0600))
+
+	for _, tc := range []struct {
+		paths   [][]string
+		decrypt bool
+	}{
+		{paths: [][]string{{"registry", "hostname"}}, decrypt: false},
+		{paths: [][]string{{"registry", "password"}}, decrypt: true},
+		{paths: [][]string{{"registry"}}, decrypt: true},
+		{paths: [][]string{{"hosts"}}, decrypt: true},
+		{paths: [][]string{{"sops", "mac"}}, decrypt: false},
+	} {
+		SetUsedKeysFunc(func() ([][]string, bool) { return tc.paths, false })
+		determineUsedKeys()
+		assert.Equal(t, tc.decrypt, needsDecryption(secretsFile), tc.paths)
+	}
+
+	// only the plaintext values get loaded without decryption
+	SetUsedKeysFunc(func() ([][]string, bool) { return [][]string{{"registry", "hostname"}}, false })
+	determineUsedKeys()
+	values = make(map[string]any)
+	loadUnencrypted(secretsFile)
+	assert.Equal(t, map[string]any{"registry": map[string]any{"hostname": "registry.example.com"}}, Values())
+	assert.False(t, viper.IsSet("registry.hostname")) // viper only gets the plato settings
+	assert.False(t, viper.IsSet("registry.password"))
+}
+
+func Test_usesEncrypted(t *testing.T) {
+	encrypted := "ENC[AES256_GCM,data:9uYY77Qeoa8dXT2h6Q==,iv:q+1Off8Cw7+eLQMinmEKY5cXrnTyQ28vfbafvH5XcVs=,tag:ZH4H/ekibHAKygMY+r7nDA==,type:str]"
+	object := map[string]any{"a": map[string]any{"b.c": map[string]any{"d": encrypted, "e": 1}}, "list": []any{1, encrypted}}
+	assert.True(t, usesEncrypted(object, []string{"a", "b", "c", "d"}))
+	assert.True(t, usesEncrypted(object, []string{"a", "b.c"}))
+	assert.True(t, usesEncrypted(object, []string{"list", "0"}))
+	assert.False(t, usesEncrypted(object, []string{"a", "b", "c", "e"}))
+	assert.False(t, usesEncrypted(object, []string{"a", "b"}))
+	assert.False(t, usesEncrypted(object, []string{"x"}))
+}
+
+func Test_withoutEncrypted(t *testing.T) {
+	encrypted := "ENC[AES256_GCM,data:9uYY77Qeoa8dXT2h6Q==,iv:q+1Off8Cw7+eLQMinmEKY5cXrnTyQ28vfbafvH5XcVs=,tag:ZH4H/ekibHAKygMY+r7nDA==,type:str]"
+	m := map[string]any{
+		"a":    map[string]any{"b": encrypted, "c_unencrypted": "visible", "sops": "kept"},
+		"list": []any{1, encrypted},
+		"sops": map[string]any{"mac": encrypted},
+	}
+	withoutEncrypted(m)
+	assert.Equal(t, map[string]any{"a": map[string]any{"c_unencrypted": "visible", "sops": "kept"}}, m)
+}
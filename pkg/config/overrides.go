@@ -0,0 +1,204 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"gopkg.in/yaml.v3"
+)
+
+// Overrides contains all values given on the command line,
+// they are applied in this order after plato.yaml and secrets.yaml have been loaded
+type Overrides struct {
+	ValuesFiles []string // -f/--values, additional (optionally SOPS-encrypted) YAML files
+	Set         []string // --set path=value, value is parsed as YAML
+	SetString   []string // --set-string path=value, value is always a string
+	SetFile     []string // --set-file path=file, value is the content of the file
+}
+
+var (
+	overrides      Overrides
+	envNamePattern = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+// SetOverrides registers command line overrides, to be applied by InitConfig.
+// All file paths are resolved right away, since InitConfig changes the working directory
+func SetOverrides(o Overrides) {
+	for i := range o.ValuesFiles {
+		o.ValuesFiles[i] = absPath(o.ValuesFiles[i])
+	}
+	for i, set := range o.SetFile {
+		if path, value, ok := strings.Cut(set, "="); ok {
+			o.SetFile[i] = path + "=" + absPath(value)
+		}
+	}
+	overrides = o
+}
+
+func absPath(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filename
+}
+
+// commandSettings are the plato settings that make plato run commands, they can only be configured in plato.yaml
+var commandSettings = []string{"plato.exec", "plato.ref_exec_allowlist"}
+
+// applyOverrides merges all values files and sets all values given on the command line
+func applyOverrides() {
+	defer keepCommandSettings()()
+	applyEnvOverrides()
+
+	for _, valuesFile := range overrides.ValuesFiles {
+		if err := loadValuesFile(valuesFile); err != nil {
+			log.Fatalf("could not load values from [%s]: %s", color.Magenta(valuesFile), color.Red("%v", err))
+		}
+		log.Infof("loaded values from [%s]", color.Magenta(valuesFile))
+	}
+
+	for _, set := range overrides.Set {
+		path, value, err := splitSet(set)
+		if err != nil {
+			log.Fatalf("invalid --set [%s]: %s", color.Magenta(set), color.Red("%v", err))
+		}
+		parsed, err := parseSetValue(value)
+		if err != nil {
+			log.Fatalf("invalid --set [%s]: %s", color.Magenta(set), color.Red("%v", err))
+		}
+		Set(path, parsed)
+	}
+	for _, set := range overrides.SetString {
+		path, value, err := splitSet(set)
+		if err != nil {
+			log.Fatalf("invalid --set-string [%s]: %s", color.Magenta(set), color.Red("%v", err))
+		}
+		Set(path, value)
+	}
+	for _, set := range overrides.SetFile {
+		path, filename, err := splitSet(set)
+		if err != nil {
+			log.Fatalf("invalid --set-file [%s]: %s", color.Magenta(set), color.Red("%v", err))
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatalf("could not read [%s]: %s", color.Magenta(filename), color.Red("%v", err))
+		}
+		Set(path, string(data))
+	}
+}
+
+// keepCommandSettings remembers the command settings of plato.yaml, the returned function restores them
+// if any values file or --set override has changed them
+func keepCommandSettings() func() {
+	configured := make(map[string]any, len(commandSettings))
+	for _, path := range commandSettings {
+		configured[path], _ = Lookup(path)
+	}
+	return func() {
+		for _, path := range commandSettings {
+			if value, _ := Lookup(path); !reflect.DeepEqual(value, configured[path]) {
+				log.Warnf("ignoring override of [%s], it can only be configured in plato.yaml", color.Magenta(path))
+				Set(path, configured[path])
+			}
+		}
+	}
+}
+
+// applyEnvOverrides replaces values with PLATO_* environment variables, the same way viper did before the values
+// were loaded without it. The variable name is the path of the value in upper case, with anything but letters,
+// digits and underscores replaced by underscores, e.g. PLATO_IMAGE_TAG for image.tag
+func applyEnvOverrides() {
+	if FoldKeys() {
+		return // viper still takes care of it
+	}
+	for _, key := range order.Keys(values) {
+		if key == "plato" || key == "sops" {
+			continue // plato settings are read from viper, which reads the environment itself
+		}
+		applyEnv(values, key, []string{key})
+	}
+}
+
+func applyEnv(m map[string]any, key string, path []string) {
+	if child, ok := m[key].(map[string]any); ok {
+		for _, childKey := range order.Keys(child) {
+			applyEnv(child, childKey, append(slices.Clone(path), childKey))
+		}
+		return
+	}
+	name := "PLATO_" + strings.ToUpper(envNamePattern.ReplaceAllString(strings.Join(path, "_"), "_"))
+	if value, ok := os.LookupEnv(name); ok {
+		log.Debugf("value [%s] is overridden by [%s]", color.Magenta(JoinPath(path)), color.Magenta(name))
+		m[key] = value
+	}
+}
+
+// splitSet splits a "path=value" command line argument
+func splitSet(set string) (string, string, error) {
+	path, value, ok := strings.Cut(set, "=")
+	if !ok {
+		return "", "", fmt.Errorf("expected format is path=value")
+	}
+	if len(strings.TrimSpace(path)) == 0 {
+		return "", "", fmt.Errorf("path must not be empty")
+	}
+	return path, value, nil
+}
+
+// parseSetValue parses a --set value as a single YAML scalar or flow collection, so "8080" becomes an integer,
+// "true" a boolean and "[a, b]" a list. Anything else, like "a: b" or "#foo", is taken as it is, as a string
+func parseSetValue(value string) (any, error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &document); err != nil {
+		return nil, err
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) != 1 || hasComment(&document) {
+		return value, nil
+	}
+	node := document.Content[0]
+	if hasComment(node) {
+		return value, nil
+	}
+	switch {
+	case node.Kind == yaml.ScalarNode && node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) == 0:
+	case (node.Kind == yaml.SequenceNode || node.Kind == yaml.MappingNode) && node.Style&yaml.FlowStyle != 0:
+	default:
+		return value, nil
+	}
+	return ordered.FromNode(&document, order)
+}
+
+func hasComment(node *yaml.Node) bool {
+	return len(node.HeadComment) > 0 || len(node.LineComment) > 0 || len(node.FootComment) > 0
+}
+
+// loadValuesFile merges an additional values file, decrypting it first if it is SOPS-encrypted
+func loadValuesFile(valuesFile string) error {
+	data, err := os.ReadFile(valuesFile)
+	if err != nil {
+		return err
+	}
+	if sops.IsEncrypted(data) {
+		if data, err = sops.Decrypt(valuesFile); err != nil {
+			return fmt.Errorf("could not decrypt with SOPS: %v", err)
+		}
+	}
+
+	if err := mergeSettings(data); err != nil {
+		return err
+	}
+	return mergeValues(data, valuesFile)
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.Initialize()
+}
+
+func Test_applyOverrides(t *testing.T) {
+	values = make(map[string]any)
+	t.Cleanup(func() {
+		values = make(map[string]any)
+		overrides = Overrides{}
+	})
+	assert.NoError(t, mergeValues([]byte("image:\n  repository: example/app\n  tag: latest\n"), "plato.yaml"))
+
+	tmp := t.TempDir()
+	valuesFile := filepath.Join(tmp, "values.yaml")
+	assert.NoError(t, os.WriteFile(valuesFile, []byte("image:\n  pullPolicy: Always\n"), 0644))
+	certFile := filepath.Join(tmp, "ca.crt")
+	assert.NoError(t, os.WriteFile(certFile, []byte("-----BEGIN CERTIFICATE-----\n"), 0644))
+
+	t.Setenv("SOPS_AGE_KEY_FILE", "../../_fixtures/age.key")
+	SetOverrides(Overrides{
+		ValuesFiles: []string{valuesFile, "../../_fixtures/secrets.yaml"},
+		Set:         []string{"image.tag=v1.2.3", "replicas=3", `annotations.app\.kubernetes\.io/name=example`, "ports=[80, 443]"},
+		SetString:   []string{"version=1.10"},
+		SetFile:     []string{"tls.ca=" + certFile},
+	})
+	applyOverrides()
+
+	assert.Equal(t, "example/app", LookupString("image.repository"))
+	assert.Equal(t, "v1.2.3", LookupString("image.tag"))
+	assert.Equal(t, "Always", LookupString("image.pullPolicy"))
+	assert.Equal(t, "registry-user", LookupString("registry.username")) // from the SOPS-encrypted values file
+
+	replicas, _ := Lookup("replicas")
+	assert.Equal(t, 3, replicas)
+	ports, _ := Lookup("ports")
+	assert.Equal(t, []any{80, 443}, ports)
+	version, _ := Lookup("version")
+	assert.Equal(t, "1.10", version)
+	assert.Equal(t, "example", LookupString("annotations.app.kubernetes.io/name"))
+	assert.Equal(t, "-----BEGIN CERTIFICATE-----\n", LookupString("tls.ca"))
+}
+
+func Test_applyOverrides_command_settings(t *testing.T) {
+	values = make(map[string]any)
+	t.Cleanup(func() {
+		values = make(map[string]any)
+		overrides = Overrides{}
+		Set("plato.exec", nil)
+		Set("plato.ref_exec_allowlist", nil)
+		Set("plato.target", nil)
+	})
+	assert.NoError(t, mergeValues([]byte("plato:\n  ref_exec_allowlist: [pass]\n"), "plato.yaml"))
+
+	valuesFile := filepath.Join(t.TempDir(), "values.yaml")
+	assert.NoError(t, os.WriteFile(valuesFile, []byte("plato:\n  exec:\n  - path: x\n    command: [sh, -c, id]\n"), 0644))
+	SetOverrides(Overrides{
+		ValuesFiles: []string{valuesFile},
+		Set:         []string{"plato.ref_exec_allowlist=[pass, sh]", "plato.target=elsewhere"},
+	})
+	applyOverrides()
+
+	assert.Equal(t, []string{"pass"}, RefExecAllowlist())
+	exec, _ := Lookup("plato.exec")
+	assert.Nil(t, exec)
+	assert.Equal(t, "elsewhere", DirTarget())
+}
+
+func Test_applyEnvOverrides(t *testing.T) {
+	values = make(map[string]any)
+	t.Cleanup(func() { values = make(map[string]any) })
+	assert.NoError(t, mergeValues([]byte("cidr: 10.0.0.0/24\nimage:\n  tag: latest\n  pullPolicy: Always\nplato:\n  target: output\n"), "plato.yaml"))
+
+	t.Setenv("PLATO_CIDR", "10.1.0.0/24")
+	t.Setenv("PLATO_IMAGE_TAG", "v1.2.3")
+	t.Setenv("PLATO_PLATO_TARGET", "elsewhere")
+	applyEnvOverrides()
+
+	assert.Equal(t, "10.1.0.0/24", LookupString("cidr"))
+	assert.Equal(t, "v1.2.3", LookupString("image.tag"))
+	assert.Equal(t, "Always", LookupString("image.pullPolicy"))
+	assert.Equal(t, "output", LookupString("plato.target"))
+}
+
+func Test_parseSetValue(t *testing.T) {
+	for value, expected := range map[string]any{
+		"8080":           8080,
+		"true":           true,
+		"null":           nil,
+		"v1.2.3":         "v1.2.3",
+		`"quoted"`:       "quoted",
+		"[80, 443]":      []any{80, 443},
+		"{a: 1}":         map[string]any{"a": 1},
+		"#foo":           "#foo",
+		"a: b":           "a: b",
+		"- a":            "- a",
+		"value # remark": "value # remark",
+		"|\n  text":      "|\n  text",
+		"":               "",
+	} {
+		parsed, err := parseSetValue(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, parsed, value)
+	}
+
+	_, err := parseSetValue("[80, 443")
+	assert.Error(t, err)
+}
+
+func Test_splitSet(t *testing.T) {
+	path, value, err := splitSet("image.tag=a=b")
+	assert.NoError(t, err)
+	assert.Equal(t, "image.tag", path)
+	assert.Equal(t, "a=b", value)
+
+	_, _, err = splitSet("image.tag")
+	assert.Error(t, err)
+	_, _, err = splitSet("=value")
+	assert.Error(t, err)
+}
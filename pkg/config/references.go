@@ -0,0 +1,247 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/command"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"github.com/spf13/viper"
+)
+
+const referencePrefix = "ref+"
+
+var (
+	// resolvedReferences caches every resolved reference, so each one is only resolved once per run
+	resolvedReferences = make(map[string]any)
+	// referencedFiles caches the parsed content of every file referenced by ref+file or ref+sops
+	referencedFiles = make(map[string]any)
+)
+
+// RefExecAllowlist returns the commands that are allowed to be run by "ref+exec://" references
+func RefExecAllowlist() []string {
+	return settingStrings("ref_exec_allowlist")
+}
+
+// resolveReferences replaces all reference strings in the template payload with the values they point to:
+//
+//	ref+env://IMAGE_TAG
+//	ref+file://certs/ca.pem
+//	ref+file://config/app.yaml#/database/host
+//	ref+sops://other/secrets.yaml#/db/password
+//	ref+exec://pass show foo
+//
+// References are only resolved along and below the value paths the templates use,
+// all of them only if these are unknown or secrets aren't loaded lazily
+func resolveReferences() {
+	settings := Values()
+	for _, key := range order.Keys(settings) {
+		if !isUsed(key) {
+			continue // references are only resolved if the templates need them
+		}
+		var resolved any
+		var err error
+		if usedKeys == nil {
+			resolved, err = resolveValue(settings[key], key)
+		} else {
+			resolved = settings[key]
+			for _, path := range usedPaths {
+				if path[0] == key && err == nil {
+					resolved, err = resolvePath(resolved, path[1:], key)
+				}
+			}
+		}
+		if err != nil {
+			log.Fatalf("could not resolve reference: %s", color.Red("%v", err))
+		}
+		if FoldKeys() {
+			viper.Set(key, resolved)
+			continue
+		}
+		settings[key] = resolved
+	}
+}
+
+// resolvePath resolves the references along a value path, and everything below it
+func resolvePath(value any, parts []string, path string) (any, error) {
+	m, ok := value.(map[string]any)
+	if len(parts) == 0 || !ok {
+		return resolveValue(value, path) // for lists or strings the template could use anything within it
+	}
+	// try longest key first, same as Lookup does
+	for i := len(parts); i > 0; i-- {
+		key := strings.Join(parts[:i], ".")
+		if item, exists := m[key]; exists {
+			resolved, err := resolvePath(item, parts[i:], path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = resolved
+			break
+		}
+	}
+	return m, nil
+}
+
+// resolveValue walks through maps and lists, resolving any reference string it finds
+func resolveValue(value any, path string) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			resolved, err := resolveValue(item, path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
+		}
+	case []any:
+		for i, item := range v {
+			resolved, err := resolveValue(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	case string:
+		if strings.HasPrefix(v, referencePrefix) {
+			resolved, err := resolveReference(v)
+			if err != nil {
+				return nil, fmt.Errorf("[%s] at [%s]: %v", v, path, err)
+			}
+			return resolved, nil
+		}
+	}
+	return value, nil
+}
+
+// resolveReference resolves a single reference string, using the cache if it was already resolved before
+func resolveReference(reference string) (any, error) {
+	if value, ok := resolvedReferences[reference]; ok {
+		return value, nil
+	}
+
+	scheme, target, ok := strings.Cut(strings.TrimPrefix(reference, referencePrefix), "://")
+	if !ok {
+		return nil, fmt.Errorf("expected format is ref+<scheme>://<target>")
+	}
+
+	var value any
+	var err error
+	switch scheme {
+	case "env":
+		value, err = resolveEnv(target)
+	case "file":
+		value, err = resolveFile(target, false)
+	case "sops":
+		value, err = resolveFile(target, true)
+	case "exec":
+		value, err = resolveExec(target)
+	default:
+		err = fmt.Errorf("unknown reference scheme [%s]", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("resolved reference [%s]", color.Magenta(reference))
+	resolvedReferences[reference] = value
+	return value, nil
+}
+
+func resolveEnv(name string) (any, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable [%s] is not set", name)
+	}
+	return value, nil
+}
+
+// resolveFile returns the content of a file, or if a "#/json/pointer" is given
+// the value found at that pointer within the parsed YAML or JSON file
+func resolveFile(target string, decrypt bool) (any, error) {
+	filename, pointer, hasPointer := strings.Cut(target, "#")
+	if !hasPointer && !decrypt {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+
+	cacheKey := filename
+	if decrypt {
+		cacheKey = "sops://" + filename
+	}
+	document, ok := referencedFiles[cacheKey]
+	if !ok {
+		var data []byte
+		var err error
+		if decrypt {
+			if data, err = sops.Decrypt(filename); err != nil {
+				return nil, fmt.Errorf("could not decrypt [%s] with SOPS: %v", filename, err)
+			}
+		} else if data, err = os.ReadFile(filename); err != nil {
+			return nil, err
+		}
+
+		if document, err = ordered.Unmarshal(data, order); err != nil {
+			return nil, fmt.Errorf("could not parse [%s]: %v", filename, err)
+		}
+		referencedFiles[cacheKey] = document
+	}
+	return resolvePointer(document, pointer)
+}
+
+// resolvePointer returns the value found at a JSON pointer (RFC 6901), like "/db/password" or "/users/0/name"
+func resolvePointer(document any, pointer string) (any, error) {
+	if len(pointer) == 0 || pointer == "/" {
+		return document, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid pointer [%s], must start with a /", pointer)
+	}
+
+	value := document
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch v := value.(type) {
+		case map[string]any:
+			item, ok := v[token]
+			if !ok {
+				return nil, fmt.Errorf("pointer [%s] not found, no key [%s]", pointer, token)
+			}
+			value = item
+		case []any:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, fmt.Errorf("pointer [%s] not found, no index [%s]", pointer, token)
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("pointer [%s] not found, [%s] is not a map or list", pointer, token)
+		}
+	}
+	return value, nil
+}
+
+// resolveExec runs a command and returns its output, the command must be listed in 'plato.ref_exec_allowlist'
+func resolveExec(target string) (any, error) {
+	args := strings.Fields(target)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no command given")
+	}
+	if !slices.Contains(RefExecAllowlist(), args[0]) {
+		return nil, fmt.Errorf("command [%s] is not listed in 'plato.ref_exec_allowlist'", args[0])
+	}
+
+	stdout, stderr, err := command.ExecSeparateOutput(command.Get(args))
+	if err != nil {
+		return nil, fmt.Errorf("command [%s] failed: %v: %s", target, err, strings.TrimSpace(stderr))
+	}
+	return strings.TrimSuffix(stdout, "\n"), nil
+}
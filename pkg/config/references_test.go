@@ -0,0 +1,93 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_resolveReferences(t *testing.T) {
+	values = make(map[string]any)
+	t.Cleanup(func() { values = make(map[string]any) })
+
+	tmp := t.TempDir()
+	certFile := filepath.Join(tmp, "ca.pem")
+	assert.NoError(t, os.WriteFile(certFile, []byte("-----BEGIN CERTIFICATE-----\n"), 0644))
+	appFile := filepath.Join(tmp, "app.json")
+	assert.NoError(t, os.WriteFile(appFile, []byte(`{"database": {"hosts": ["db-1", "db-2"], "a/b": 1}}`), 0644))
+
+	t.Setenv("PLATO_TEST_IMAGE_TAG", "v1.2.3")
+	t.Setenv("SOPS_AGE_KEY_FILE", "../../_fixtures/age.key")
+	Set("plato.ref_exec_allowlist", []string{"echo"})
+	t.Cleanup(func() { Set("plato.ref_exec_allowlist", nil) })
+
+	assert.NoError(t, mergeValues([]byte(`
+image:
+  tag: ref+env://PLATO_TEST_IMAGE_TAG
+tls:
+  ca: ref+file://`+certFile+`
+database:
+  host: ref+file://`+appFile+`#/database/hosts/1
+  escaped: ref+file://`+appFile+`#/database/a~1b
+  password: ref+sops://../../_fixtures/secrets.yaml#/registry/password
+list:
+- ref+exec://echo hello world
+- plain
+`), "plato.yaml"))
+	resolveReferences()
+
+	assert.Equal(t, "v1.2.3", LookupString("image.tag"))
+	assert.Equal(t, "-----BEGIN CERTIFICATE-----\n", LookupString("tls.ca"))
+	assert.Equal(t, "db-2", LookupString("database.host"))
+	assert.Equal(t, "1", LookupString("database.escaped"))
+	assert.Equal(t, "super-secret-password-here!", LookupString("database.password"))
+	list, _ := Lookup("list")
+	assert.Equal(t, []any{"hello world", "plain"}, list)
+}
+
+func Test_resolveReferences_used_paths(t *testing.T) {
+	values = make(map[string]any)
+	t.Cleanup(func() {
+		values = make(map[string]any)
+		usedKeys, usedPaths = nil, nil
+	})
+	t.Setenv("PLATO_TEST_IMAGE_TAG", "v1.2.3")
+	t.Setenv("PLATO_TEST_REGISTRY", "ref+env://PLATO_TEST_IMAGE_TAG")
+
+	assert.NoError(t, mergeValues([]byte(`
+image:
+  tag: ref+env://PLATO_TEST_IMAGE_TAG
+  unused: ref+env://PLATO_TEST_DOES_NOT_EXIST
+registry: ref+env://PLATO_TEST_REGISTRY
+unused: ref+exec://not allowlisted
+`), "plato.yaml"))
+	SetUsedKeysFunc(func() ([][]string, bool) { return [][]string{{"image", "tag"}, {"registry"}}, false })
+	t.Cleanup(func() { SetUsedKeysFunc(nil) })
+	determineUsedKeys()
+	resolveReferences()
+
+	assert.Equal(t, "v1.2.3", LookupString("image.tag"))
+	assert.Equal(t, "ref+env://PLATO_TEST_DOES_NOT_EXIST", LookupString("image.unused"))
+	assert.Equal(t, "ref+env://PLATO_TEST_IMAGE_TAG", LookupString("registry")) // references are not resolved recursively
+	assert.Equal(t, "ref+exec://not allowlisted", LookupString("unused"))
+}
+
+func Test_resolveReference_errors(t *testing.T) {
+	_, err := resolveReference("ref+env://PLATO_TEST_DOES_NOT_EXIST")
+	assert.Error(t, err)
+	_, err = resolveReference("ref+unknown://something")
+	assert.Error(t, err)
+	_, err = resolveReference("ref+env")
+	assert.Error(t, err)
+
+	// commands must be allowlisted
+	_, err = resolveReference("ref+exec://cat /etc/hostname")
+	assert.ErrorContains(t, err, "ref_exec_allowlist")
+
+	_, err = resolvePointer(map[string]any{"a": []any{"b"}}, "/a/1")
+	assert.Error(t, err)
+	_, err = resolvePointer(map[string]any{"a": "b"}, "a")
+	assert.Error(t, err)
+}
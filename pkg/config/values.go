@@ -0,0 +1,243 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// values contains the template payload, loaded with exact keys, key order and types.
+// Unlike viper it does not lowercase keys or split them on dots
+var values = make(map[string]any)
+
+// order keeps the original key order of all maps ever loaded into the template payload
+var order = ordered.NewOrder()
+
+// FoldKeys determines if the template payload should be taken from viper instead,
+// with all keys lowercased and split on dots. This is the old behavior, kept for compatibility.
+// It is the only plato setting always read from viper, since it decides where all others are read from
+func FoldKeys() bool {
+	return viper.GetBool("plato.fold_keys")
+}
+
+// mergeSettings merges the plato settings of YAML data into viper, which plato settings are read from.
+// Everything else is only merged into the template payload, unless keys are folded the old way
+func mergeSettings(data []byte, filters ...func(map[string]any)) error {
+	settings := make(map[string]any)
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	for _, filter := range filters {
+		filter(settings)
+	}
+	if !FoldKeys() {
+		plato, ok := settings["plato"]
+		if !ok {
+			return nil
+		}
+		settings = map[string]any{"plato": plato}
+	}
+	return viper.MergeConfigMap(settings)
+}
+
+// Values returns the template payload, all of plato.yaml and the decrypted secrets merged together
+func Values() map[string]any {
+	if FoldKeys() {
+		return viper.AllSettings()
+	}
+	return values
+}
+
+// Keys returns the keys of a map of the template payload in their original order, or sorted if unknown,
+// for example for maps copied by template functions
+func Keys(m map[string]any) []string {
+	return order.Keys(m)
+}
+
+// Lookup returns the value found under the given path. Path segments are separated by dots,
+// keys that contain dots themselves are matched either as-is or with the dots escaped as "\."
+func Lookup(path string) (any, bool) {
+	if FoldKeys() {
+		return viper.Get(path), viper.IsSet(path)
+	}
+	return lookup(values, SplitPath(path))
+}
+
+// LookupIn returns the value found under the given path within any object, the same way Lookup does
+func LookupIn(object any, path string) (any, bool) {
+	return lookup(object, SplitPath(path))
+}
+
+func lookup(object any, parts []string) (any, bool) {
+	if len(parts) == 0 {
+		return object, true
+	}
+	m, ok := object.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	// try longest key first, to find keys that contain dots themselves
+	for i := len(parts); i > 0; i-- {
+		if value, exists := m[strings.Join(parts[:i], ".")]; exists {
+			if found, ok := lookup(value, parts[i:]); ok {
+				return found, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// KeyPathIn returns the keys the given path refers to within any object, matched the same way LookupIn does.
+// Parts of the path that don't exist yet are split on dots, like SplitPath does
+func KeyPathIn(object any, path string) []string {
+	keys, _ := keyPath(object, SplitPath(path))
+	return keys
+}
+
+func keyPath(object any, parts []string) ([]string, bool) {
+	if len(parts) == 0 {
+		return nil, true
+	}
+	m, ok := object.(map[string]any)
+	if !ok {
+		return parts, false
+	}
+	// try longest key first, same as lookup, but keep the longest partial match for paths that don't exist yet
+	var partial []string
+	for i := len(parts); i > 0; i-- {
+		key := strings.Join(parts[:i], ".")
+		if value, exists := m[key]; exists {
+			rest, found := keyPath(value, parts[i:])
+			if found {
+				return append([]string{key}, rest...), true
+			}
+			if partial == nil {
+				partial = append([]string{key}, rest...)
+			}
+		}
+	}
+	if partial != nil {
+		return partial, false
+	}
+	return parts, false
+}
+
+// LookupString returns the value found under the given path as a string, or an empty string if there is none
+func LookupString(path string) string {
+	value, ok := Lookup(path)
+	if !ok || value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// Set stores a value under the given path, creating any missing maps along the way
+func Set(path string, value any) {
+	if FoldKeys() {
+		viper.Set(path, value)
+		return
+	}
+	if strings.HasPrefix(path, "plato.") {
+		viper.Set(path, value) // plato settings are always read from viper
+	}
+	parts := SplitPath(path)
+	m := values
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			setKey(m, part, next)
+		}
+		m = next
+	}
+	setKey(m, parts[len(parts)-1], value)
+}
+
+// setKey sets a map value, appending new keys to the end of the known key order
+func setKey(m map[string]any, key string, value any) {
+	if _, exists := m[key]; !exists {
+		order.Remember(m, append(order.Keys(m), key))
+	}
+	m[key] = value
+}
+
+// SplitPath splits a value path on dots, dots escaped as "\." are kept as part of the key
+func SplitPath(path string) []string {
+	parts := make([]string, 0)
+	var current strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path) && path[i+1] == '.':
+			current.WriteByte('.')
+			i++
+		case path[i] == '.':
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(path[i])
+		}
+	}
+	return append(parts, current.String())
+}
+
+// JoinPath is the counterpart of SplitPath, joining path segments with dots and escaping the dots within them
+func JoinPath(parts []string) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = strings.ReplaceAll(part, ".", "\\.")
+	}
+	return strings.Join(escaped, ".")
+}
+
+// Merge deep-merges src into dst, values from src take precedence. New keys are appended in their src order
+func Merge(dst, src map[string]any) {
+	for _, key := range order.Keys(src) {
+		srcMap, srcIsMap := src[key].(map[string]any)
+		dstMap, dstIsMap := dst[key].(map[string]any)
+		if srcIsMap && dstIsMap {
+			Merge(dstMap, srcMap)
+			continue
+		}
+		setKey(dst, key, src[key])
+	}
+}
+
+// mergeValues parses YAML data and deep-merges it into the template payload, after applying any given filters
+func mergeValues(data []byte, source string, filters ...func(map[string]any)) error {
+	object, err := ordered.Unmarshal(data, order)
+	if err != nil {
+		return fmt.Errorf("could not parse [%s]: %v", source, err)
+	}
+	if object == nil {
+		return nil
+	}
+	m, ok := object.(map[string]any)
+	if !ok {
+		return fmt.Errorf("could not parse [%s]: top-level value must be a map", source)
+	}
+	for _, filter := range filters {
+		filter(m)
+	}
+	Merge(values, m)
+	return nil
+}
+
+// loadValues reads a plaintext YAML file into the template payload
+func loadValues(inputFile string) {
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		log.Fatalf("could not read [%s]: %s", color.Magenta(inputFile), color.Red("%v", err))
+	}
+	if err := mergeValues(data, inputFile); err != nil {
+		log.Fatalf("%s", color.Red("%v", err))
+	}
+}
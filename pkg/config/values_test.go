@@ -0,0 +1,60 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SplitPath(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, SplitPath("a.b.c"))
+	assert.Equal(t, []string{"ingress", "nginx.org/ssl", "enabled"}, SplitPath(`ingress.nginx\.org/ssl.enabled`))
+	assert.Equal(t, []string{"single"}, SplitPath("single"))
+	assert.Equal(t, `ingress.nginx\.org/ssl.enabled`, JoinPath([]string{"ingress", "nginx.org/ssl", "enabled"}))
+}
+
+func Test_KeyPathIn(t *testing.T) {
+	object := map[string]any{"db.url": "x", "db": map[string]any{"user": "y"}, "a": map[string]any{"b.c": map[string]any{}}}
+	assert.Equal(t, []string{"db.url"}, KeyPathIn(object, "db.url"))
+	assert.Equal(t, []string{"db", "user"}, KeyPathIn(object, "db.user"))
+	assert.Equal(t, []string{"db", "password"}, KeyPathIn(object, "db.password"))
+	assert.Equal(t, []string{"a", "b.c", "d"}, KeyPathIn(object, "a.b.c.d"))
+	assert.Equal(t, []string{"new", "key"}, KeyPathIn(object, "new.key"))
+	assert.Equal(t, []string{"db.url", "more"}, KeyPathIn(object, `db\.url.more`))
+}
+
+func Test_mergeValues(t *testing.T) {
+	values = make(map[string]any)
+	t.Cleanup(func() { values = make(map[string]any) })
+
+	assert.NoError(t, mergeValues([]byte(`
+service:
+  Name: Example
+  port: 8080
+  annotations:
+    nginx.org/ssl-services: example
+`), "plato.yaml"))
+	assert.NoError(t, mergeValues([]byte(`
+service:
+  password: secret
+  port: 8443
+`), "secrets.yaml"))
+
+	service := Values()["service"].(map[string]any)
+	assert.Equal(t, []string{"Name", "port", "annotations", "password"}, Keys(service))
+	assert.Equal(t, "Example", LookupString("service.Name"))
+	assert.Equal(t, 8443, service["port"])
+
+	// keys containing dots are found either as-is or escaped
+	assert.Equal(t, "example", LookupString("service.annotations.nginx.org/ssl-services"))
+	assert.Equal(t, "example", LookupString(`service.annotations.nginx\.org/ssl-services`))
+
+	_, ok := Lookup("service.name")
+	assert.False(t, ok)
+
+	Set(`service.annotations.example\.com/tls`, true)
+	assert.Equal(t, "true", LookupString(`service.annotations.example\.com/tls`))
+	assert.Equal(t, []string{"nginx.org/ssl-services", "example.com/tls"}, Keys(service["annotations"].(map[string]any)))
+
+	assert.Error(t, mergeValues([]byte(`- not a map`), "list.yaml"))
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"path"
 
+	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 )
 
@@ -13,43 +15,81 @@ var (
 	delimiterLeft       = "{{{"
 	delimiterRight      = "}}}"
 	secretsFile         = ""
+	stableHashes        = true
+	hashPepper          = "hash_pepper"
+	secretsSeparator    = "."
 )
 
+// setting returns a plato setting. Settings are read from the values like everything else, where the keys of map
+// settings are kept exactly as written, viper only provides the settings needed before the values are loaded
+func setting(key string) (any, bool) {
+	if value, ok := Lookup("plato." + key); ok {
+		return value, true
+	}
+	return viper.Get("plato." + key), viper.IsSet("plato." + key)
+}
+
+func settingString(key string) string {
+	value, _ := setting(key)
+	return cast.ToString(value)
+}
+
+func settingStrings(key string) []string {
+	value, _ := setting(key)
+	return cast.ToStringSlice(value)
+}
+
+func settingBool(key string, defaultValue bool) bool {
+	value, ok := setting(key)
+	if !ok || value == nil {
+		return defaultValue
+	}
+	return cast.ToBool(value)
+}
+
 func DirRoot() string {
 	return path.Dir("/")
 }
 
 func DirSource() string {
-	if len(viper.GetString("plato.source")) > 0 {
-		return viper.GetString("plato.source")
+	if value := settingString("source"); len(value) > 0 {
+		return value
 	}
 	return dirSource
 }
 
 func DirTarget() string {
-	if len(viper.GetString("plato.target")) > 0 {
-		return viper.GetString("plato.target")
+	if value := settingString("target"); len(value) > 0 {
+		return value
 	}
 	return dirTarget
 }
 
 func DirGeneratedSecrets() string {
-	if len(viper.GetString("plato.secrets")) > 0 {
-		return viper.GetString("plato.secrets")
+	if value := settingString("secrets"); len(value) > 0 {
+		return value
 	}
 	return dirGeneratedSecrets
 }
 
+// DirAssets is the base directory for file-reading template functions, defaults to DirSource()
+func DirAssets() string {
+	if value := settingString("assets"); len(value) > 0 {
+		return value
+	}
+	return DirSource()
+}
+
 func DelimiterLeft() string {
-	if len(viper.GetString("plato.delimiters.left")) > 0 {
-		return viper.GetString("plato.delimiters.left")
+	if value := settingString("delimiters.left"); len(value) > 0 {
+		return value
 	}
 	return delimiterLeft
 }
 
 func DelimiterRight() string {
-	if len(viper.GetString("plato.delimiters.right")) > 0 {
-		return viper.GetString("plato.delimiters.right")
+	if value := settingString("delimiters.right"); len(value) > 0 {
+		return value
 	}
 	return delimiterRight
 }
@@ -57,3 +97,126 @@ func DelimiterRight() string {
 func SecretsFile() string {
 	return secretsFile
 }
+
+// SecretsSeparator returns the separator on which generated secret filenames are split into nested keys.
+// Set it to "/" to never split filenames, since it can't be part of one
+func SecretsSeparator() string {
+	if value := settingString("secrets_separator"); len(value) > 0 {
+		return value
+	}
+	return secretsSeparator
+}
+
+// SecretsDirectories determines if subdirectories of DirGeneratedSecrets() become nested keys in secrets.yaml,
+// otherwise only the filename is used, as it always has been
+func SecretsDirectories() bool {
+	return settingBool("secrets_directories", false)
+}
+
+// SecretsMap returns the explicit value paths of generated secrets, keyed by their file path relative to DirGeneratedSecrets()
+func SecretsMap() (map[string]string, error) {
+	value, ok := setting("secrets_map")
+	if !ok || value == nil {
+		return map[string]string{}, nil
+	}
+	m, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("'plato.secrets_map' must be a map of files to value paths")
+	}
+	secretsMap := make(map[string]string, len(m))
+	for filename, valuePath := range m {
+		s, ok := valuePath.(string)
+		if !ok || len(s) == 0 {
+			return nil, fmt.Errorf("'plato.secrets_map.%s' must be a value path", filename)
+		}
+		secretsMap[filename] = s
+	}
+	return secretsMap, nil
+}
+
+// RotationSpec describes how "plato rotate" generates a new value for a secret
+type RotationSpec struct {
+	Type       string // password (default), ssh, wireguard or tls
+	Length     int    // length of passwords, default is 32
+	Charset    string // characters of passwords, "alphanumeric" (default), "alpha", "numeric", "hex", "ascii" or the characters themselves
+	CommonName string // common name of TLS certificates, default is the last key of the value path
+	Days       int    // validity of TLS certificates, default is 365
+}
+
+// RotatableSecrets returns all secrets declared in 'plato.rotate', keyed by their value path
+func RotatableSecrets() (map[string]RotationSpec, error) {
+	value, ok := setting("rotate")
+	if !ok || value == nil {
+		return map[string]RotationSpec{}, nil
+	}
+	m, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("'plato.rotate' must be a map of value paths to generator specs")
+	}
+	specs := make(map[string]RotationSpec, len(m))
+	for valuePath, entry := range m {
+		spec := RotationSpec{Type: "password"}
+		switch e := entry.(type) {
+		case nil:
+		case map[string]any:
+			if t, ok := e["type"].(string); ok && len(t) > 0 {
+				spec.Type = t
+			}
+			spec.Charset, _ = e["charset"].(string)
+			spec.CommonName, _ = e["common_name"].(string)
+			spec.Length = toInt(e["length"])
+			spec.Days = toInt(e["days"])
+		default:
+			return nil, fmt.Errorf("'plato.rotate.%s' must be a generator spec", valuePath)
+		}
+		switch spec.Type {
+		case "password", "ssh", "wireguard", "tls":
+		default:
+			return nil, fmt.Errorf("'plato.rotate.%s' has unknown type [%s], must be one of password, ssh, wireguard or tls", valuePath, spec.Type)
+		}
+		specs[valuePath] = spec
+	}
+	return specs, nil
+}
+
+func toInt(value any) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case uint64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// SecretsNamespace returns the value path below which "store-secrets --prune" removes keys without a generated secret
+func SecretsNamespace() string {
+	return settingString("secrets_namespace")
+}
+
+// StructuredSecrets determines if generated .yaml and .json secrets are stored as subtrees instead of strings
+func StructuredSecrets() bool {
+	return settingBool("secrets_structured", false)
+}
+
+// StableHashes determines if password hashing functions derive their salt deterministically
+func StableHashes() bool {
+	return settingBool("stable_hashes", stableHashes)
+}
+
+// SOPSBackend returns which implementation decrypts and encrypts SOPS files, either "cli" (default) or "age"
+func SOPSBackend() string {
+	return settingString("sops_backend")
+}
+
+// HashPepper returns the value path under which the pepper for stable hashes is stored
+func HashPepper() string {
+	if value := settingString("hash_pepper"); len(value) > 0 {
+		return value
+	}
+	return hashPepper
+}
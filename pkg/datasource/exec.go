@@ -0,0 +1,83 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/plato/pkg/util/command"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+)
+
+// executed caches the parsed output of every command already run, so each command only runs once per render
+var executed = make(map[string]any)
+
+// defaultEnv are the environment variables every command sees, most tools find their configuration through HOME
+var defaultEnv = []string{"PATH", "HOME"}
+
+// Exec runs a command and parses its stdout according to format, either "raw" (default), "yaml" or "json".
+// The command only sees PATH, HOME and the environment variables listed in env, and is killed after the timeout.
+// The key order of parsed maps is remembered in the given order
+func Exec(cmd []string, format string, timeout time.Duration, env []string, order *ordered.Order) (any, error) {
+	if len(cmd) == 0 {
+		return nil, fmt.Errorf("no command given")
+	}
+	if len(format) == 0 {
+		format = "raw"
+	}
+	if format != "raw" && format != "yaml" && format != "json" {
+		return nil, fmt.Errorf("unknown format [%s], must be one of raw, yaml or json", format)
+	}
+
+	cacheKey := fmt.Sprintf("%s:%q", format, cmd)
+	if value, ok := executed[cacheKey]; ok {
+		return value, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c := command.GetWithContext(ctx, cmd)
+	c.Env = allowedEnv(env)
+
+	stdout, stderr, err := command.ExecSeparateOutput(c)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("command [%s] timed out after %v", strings.Join(cmd, " "), timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("command [%s] failed: %v: %s", strings.Join(cmd, " "), err, strings.TrimSpace(stderr))
+	}
+
+	if format == "json" && !json.Valid([]byte(stdout)) {
+		return nil, fmt.Errorf("output of [%s] is not valid json", strings.Join(cmd, " "))
+	}
+	var value any = strings.TrimSuffix(stdout, "\n")
+	switch format {
+	case "json":
+		if value, err = ordered.UnmarshalJSON([]byte(stdout), order); err != nil {
+			return nil, fmt.Errorf("could not parse output of [%s] as %s: %v", strings.Join(cmd, " "), format, err)
+		}
+	case "yaml":
+		if value, err = ordered.Unmarshal([]byte(stdout), order); err != nil {
+			return nil, fmt.Errorf("could not parse output of [%s] as %s: %v", strings.Join(cmd, " "), format, err)
+		}
+	}
+	executed[cacheKey] = value
+	return value, nil
+}
+
+// allowedEnv returns the default and all allowlisted environment variables of the current process
+func allowedEnv(allowlist []string) []string {
+	env := make([]string, 0)
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if slices.Contains(defaultEnv, name) || slices.Contains(allowlist, name) {
+			env = append(env, variable)
+		}
+	}
+	return env
+}
@@ -0,0 +1,73 @@
+package datasource
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Exec(t *testing.T) {
+	value, err := Exec([]string{"echo", "s3cr3t"}, "", time.Second, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", value)
+
+	order := ordered.NewOrder()
+	value, err = Exec([]string{"sh", "-c", "echo 'username: web'; echo 'port: 8080'"}, "yaml", time.Second, nil, order)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"username", "port"}, order.Keys(value.(map[string]any)))
+	assert.Equal(t, 8080, value.(map[string]any)["port"])
+
+	value, err = Exec([]string{"echo", `{"token": "abc"}`}, "json", time.Second, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", value.(map[string]any)["token"])
+
+	_, err = Exec([]string{"echo", "token: abc"}, "json", time.Second, nil, nil)
+	assert.ErrorContains(t, err, "not valid json")
+	_, err = Exec([]string{"echo"}, "xml", time.Second, nil, nil)
+	assert.ErrorContains(t, err, "unknown format")
+}
+
+func Test_Exec_env_allowlist(t *testing.T) {
+	t.Setenv("PLATO_TEST_ALLOWED", "allowed")
+	t.Setenv("PLATO_TEST_SECRET", "secret")
+	t.Setenv("HOME", "/home/plato")
+
+	value, err := Exec([]string{"sh", "-c", `echo "$PLATO_TEST_ALLOWED-$PLATO_TEST_SECRET-$HOME"`}, "raw", time.Second, []string{"PLATO_TEST_ALLOWED"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "allowed--/home/plato", value)
+}
+
+func Test_Exec_errors(t *testing.T) {
+	_, err := Exec([]string{"sh", "-c", "echo 'item not found' >&2; exit 1"}, "raw", time.Second, nil, nil)
+	assert.ErrorContains(t, err, "item not found")
+
+	_, err = Exec([]string{"sleep", "5"}, "raw", 100*time.Millisecond, nil, nil)
+	assert.ErrorContains(t, err, "timed out")
+
+	// processes started by the command are killed too
+	start := time.Now()
+	_, err = Exec([]string{"sh", "-c", "sleep 3; echo done"}, "raw", 200*time.Millisecond, nil, nil)
+	assert.ErrorContains(t, err, "timed out")
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	_, err = Exec(nil, "raw", time.Second, nil, nil)
+	assert.Error(t, err)
+}
+
+func Test_Exec_cache(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "counter")
+	cmd := []string{"sh", "-c", "echo run >> " + counter + "; echo value"}
+
+	for range 3 {
+		value, err := Exec(cmd, "raw", time.Second, nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "value", value)
+	}
+	data, err := os.ReadFile(counter)
+	assert.NoError(t, err)
+	assert.Equal(t, "run\n", string(data))
+}
@@ -0,0 +1,133 @@
+package datasource
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+)
+
+// TerraformState reads a local Terraform state file and returns its output values under "outputs".
+// If resources is true, the attributes of all resources are returned too, under "resources.<type>.<name>",
+// prefixed with "data" for data sources and with the module address for resources within modules.
+// State files ending in .sops_enc are decrypted with SOPS first. The key order of all maps is remembered in the given order
+func TerraformState(filename string, resources bool, order *ordered.Order) (map[string]any, error) {
+	var data []byte
+	var err error
+	if filepath.Ext(filename) == ".sops_enc" {
+		if data, err = sops.Decrypt(filename); err != nil {
+			return nil, fmt.Errorf("could not decrypt [%s] with SOPS: %v", filename, err)
+		}
+	} else if data, err = os.ReadFile(filename); err != nil {
+		return nil, err
+	}
+
+	document, err := ordered.UnmarshalJSON(data, order)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse [%s]: %v", filename, err)
+	}
+	state, ok := document.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("[%s] is not a Terraform state file", filename)
+	}
+	if version, _ := state["version"].(int); version != 4 {
+		return nil, fmt.Errorf("[%s] has unsupported Terraform state version [%v], only version 4 is supported", filename, state["version"])
+	}
+
+	result := map[string]any{"outputs": terraformOutputs(state, order)}
+	keys := []string{"outputs"}
+	if resources {
+		result["resources"] = terraformResources(state, order)
+		keys = append(keys, "resources")
+	}
+	order.Remember(result, keys)
+	return result, nil
+}
+
+// terraformOutputs returns all output values, same as "terraform output -json" would, but without the type information
+func terraformOutputs(state map[string]any, order *ordered.Order) map[string]any {
+	outputs := make(map[string]any)
+	stateOutputs, _ := state["outputs"].(map[string]any)
+	keys := order.Keys(stateOutputs)
+	for _, name := range keys {
+		if output, ok := stateOutputs[name].(map[string]any); ok {
+			outputs[name] = output["value"]
+		}
+	}
+	order.Remember(outputs, keys)
+	return outputs
+}
+
+func terraformResources(state map[string]any, order *ordered.Order) map[string]any {
+	resources := make(map[string]any)
+	stateResources, _ := state["resources"].([]any)
+	for _, r := range stateResources {
+		resource, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		path := make([]string, 0)
+		if module, _ := resource["module"].(string); len(module) > 0 {
+			path = append(path, module)
+		}
+		if mode, _ := resource["mode"].(string); mode == "data" {
+			path = append(path, "data")
+		}
+		path = append(path, fmt.Sprintf("%v", resource["type"]), fmt.Sprintf("%v", resource["name"]))
+		setPath(resources, path, terraformInstances(resource, order), order)
+	}
+	return resources
+}
+
+// terraformInstances returns the attributes of a single resource instance,
+// or a list or map of them if the resource uses count or for_each
+func terraformInstances(resource map[string]any, order *ordered.Order) any {
+	instances, _ := resource["instances"].([]any)
+	if len(instances) == 1 {
+		if instance, ok := instances[0].(map[string]any); ok && instance["index_key"] == nil {
+			return instance["attributes"]
+		}
+	}
+
+	list := make([]any, 0)
+	byKey := make(map[string]any)
+	keys := make([]string, 0)
+	for _, i := range instances {
+		instance, ok := i.(map[string]any)
+		if !ok {
+			continue
+		}
+		if key, ok := instance["index_key"].(string); ok { // for_each
+			byKey[key] = instance["attributes"]
+			keys = append(keys, key)
+			continue
+		}
+		list = append(list, instance["attributes"]) // count
+	}
+	if len(keys) > 0 {
+		order.Remember(byKey, keys)
+		return byKey
+	}
+	return list
+}
+
+// setPath sets a value in nested maps, creating any missing maps along the way
+func setPath(m map[string]any, path []string, value any, order *ordered.Order) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			order.Remember(m, append(order.Keys(m), key))
+			m[key] = next
+		}
+		m = next
+	}
+	key := path[len(path)-1]
+	if _, exists := m[key]; !exists {
+		order.Remember(m, append(order.Keys(m), key))
+	}
+	m[key] = value
+}
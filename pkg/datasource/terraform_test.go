@@ -0,0 +1,95 @@
+package datasource
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JamesClonk/plato/pkg/util/command"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.Initialize()
+}
+
+const testState = `{
+  "version": 4,
+  "terraform_version": "1.9.5",
+  "serial": 3,
+  "lineage": "1f0d3b2c-5a4e-4c8f-9d7a-2b6e8f0a1c3d",
+  "outputs": {
+    "vpc_id": { "value": "vpc-0a1b2c3d", "type": "string" },
+    "subnet_ids": { "value": ["subnet-1", "subnet-2"], "type": ["list", "string"] },
+    "db_password": { "value": "s3cr3t", "type": "string", "sensitive": true }
+  },
+  "resources": [
+    {
+      "mode": "managed", "type": "hcloud_server", "name": "master", "provider": "provider[\"registry.terraform.io/hetznercloud/hcloud\"]",
+      "instances": [ { "schema_version": 0, "attributes": { "id": "1001", "ipv4_address": "10.0.0.1" } } ]
+    },
+    {
+      "mode": "managed", "type": "hcloud_server", "name": "worker", "provider": "provider[\"registry.terraform.io/hetznercloud/hcloud\"]",
+      "instances": [
+        { "index_key": 0, "schema_version": 0, "attributes": { "id": "2001" } },
+        { "index_key": 1, "schema_version": 0, "attributes": { "id": "2002" } }
+      ]
+    },
+    {
+      "module": "module.dns", "mode": "data", "type": "hcloud_zone", "name": "main", "provider": "provider[\"registry.terraform.io/hetznercloud/hcloud\"]",
+      "instances": [ { "index_key": "example.com", "schema_version": 0, "attributes": { "id": "z1" } } ]
+    }
+  ]
+}`
+
+func Test_TerraformState(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "terraform.tfstate")
+	assert.NoError(t, os.WriteFile(filename, []byte(testState), 0600))
+
+	order := ordered.NewOrder()
+	state, err := TerraformState(filename, false, order)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"outputs"}, order.Keys(state))
+	outputs := state["outputs"].(map[string]any)
+	assert.Equal(t, []string{"vpc_id", "subnet_ids", "db_password"}, order.Keys(outputs))
+	assert.Equal(t, "vpc-0a1b2c3d", outputs["vpc_id"])
+	assert.Equal(t, []any{"subnet-1", "subnet-2"}, outputs["subnet_ids"])
+
+	state, err = TerraformState(filename, true, nil)
+	assert.NoError(t, err)
+	resources := state["resources"].(map[string]any)
+	servers := resources["hcloud_server"].(map[string]any)
+	assert.Equal(t, "10.0.0.1", servers["master"].(map[string]any)["ipv4_address"])
+	assert.Len(t, servers["worker"], 2)
+	assert.Equal(t, "2002", servers["worker"].([]any)[1].(map[string]any)["id"])
+	zones := resources["module.dns"].(map[string]any)["data"].(map[string]any)["hcloud_zone"].(map[string]any)
+	assert.Equal(t, "z1", zones["main"].(map[string]any)["example.com"].(map[string]any)["id"])
+}
+
+func Test_TerraformState_sops_enc(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY_FILE", "../../_fixtures/age.key")
+	filename := filepath.Join(t.TempDir(), "terraform.tfstate.sops_enc")
+	assert.NoError(t, os.WriteFile(filename, []byte(testState), 0600))
+	_, err := command.ExecOutput([]string{"sops", "-e", "-i", "--age", "age1yapc0k0tfz8cketuldrjq3vyuzne4587zmf3d2ejypaftg95yvrs8r44yh", filename})
+	assert.NoError(t, err)
+
+	state, err := TerraformState(filename, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", state["outputs"].(map[string]any)["db_password"])
+}
+
+func Test_TerraformState_invalid(t *testing.T) {
+	// the fixture is not an actual Terraform state file
+	_, err := TerraformState("../../_fixtures/input/infrastructure/terraform/terraform.tfstate", false, nil)
+	assert.Error(t, err)
+
+	filename := filepath.Join(t.TempDir(), "terraform.tfstate")
+	assert.NoError(t, os.WriteFile(filename, []byte(`{"version": 3, "modules": []}`), 0600))
+	_, err = TerraformState(filename, false, nil)
+	assert.ErrorContains(t, err, "unsupported Terraform state version")
+
+	_, err = TerraformState(filepath.Join(t.TempDir(), "missing.tfstate"), false, nil)
+	assert.Error(t, err)
+}
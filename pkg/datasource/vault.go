@@ -0,0 +1,119 @@
+package datasource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+)
+
+// VaultClient reads secrets from a HashiCorp Vault KV version 2 secrets engine
+type VaultClient struct {
+	Address    string
+	Namespace  string
+	Token      string
+	HTTPClient *http.Client
+}
+
+func NewVaultClient(address, namespace, token string) *VaultClient {
+	return &VaultClient{
+		Address:    strings.TrimSuffix(address, "/"),
+		Namespace:  namespace,
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// AppRoleLogin authenticates with an AppRole and uses the resulting client token for all further requests
+func (c *VaultClient) AppRoleLogin(mount, roleID, secretID string) error {
+	body, err := json.Marshal(map[string]string{"role_id": roleID, "secret_id": secretID})
+	if err != nil {
+		return err
+	}
+	response, err := c.request(http.MethodPost, fmt.Sprintf("auth/%s/login", strings.Trim(mount, "/")), body)
+	if err != nil {
+		return fmt.Errorf("AppRole login failed: %v", err)
+	}
+
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := json.Unmarshal(response, &login); err != nil {
+		return fmt.Errorf("could not parse AppRole login response: %v", err)
+	}
+	if len(login.Auth.ClientToken) == 0 {
+		return fmt.Errorf("AppRole login response did not contain a client token")
+	}
+	c.Token = login.Auth.ClientToken
+	return nil
+}
+
+// ReadKV returns the latest version of the secret stored at the given path of a KV version 2 mount,
+// remembering the key order of its maps in the given order
+func (c *VaultClient) ReadKV(mount, path string, order *ordered.Order) (map[string]any, error) {
+	response, err := c.request(http.MethodGet, fmt.Sprintf("%s/data/%s", strings.Trim(mount, "/"), strings.Trim(path, "/")), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not read [%s/%s]: %v", mount, path, err)
+	}
+
+	document, err := ordered.UnmarshalJSON(response, order)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse [%s/%s]: %v", mount, path, err)
+	}
+	outer, _ := document.(map[string]any)
+	data, _ := outer["data"].(map[string]any)
+	secret, ok := data["data"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("[%s/%s] is not a KV version 2 secret", mount, path)
+	}
+	return secret, nil
+}
+
+func (c *VaultClient) request(method, path string, body []byte) ([]byte, error) {
+	endpoint, err := url.JoinPath(c.Address, "v1", path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Token) > 0 {
+		req.Header.Set("X-Vault-Token", c.Token)
+	}
+	if len(c.Namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		if err := json.Unmarshal(data, &vaultErr); err == nil && len(vaultErr.Errors) > 0 {
+			return nil, fmt.Errorf("%s: %s", resp.Status, strings.Join(vaultErr.Errors, ", "))
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return data, nil
+}
@@ -0,0 +1,68 @@
+package datasource
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"github.com/stretchr/testify/assert"
+)
+
+func newVaultTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/auth/approle/login", func(w http.ResponseWriter, r *http.Request) {
+		var login map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&login)
+		if login["role_id"] != "my-role" || login["secret_id"] != "my-secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["invalid role or secret ID"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"auth":{"client_token":"approle-token"}}`))
+	})
+	mux.HandleFunc("GET /v1/secret/data/apps/web", func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get("X-Vault-Token"); token != "root" && token != "approle-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"username":"web","password":"s3cr3t","port":8080},"metadata":{"version":3}}}`))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_VaultClient_ReadKV(t *testing.T) {
+	server := newVaultTestServer(t)
+
+	client := NewVaultClient(server.URL+"/", "", "root")
+	order := ordered.NewOrder()
+	secret, err := client.ReadKV("secret", "/apps/web", order)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"username", "password", "port"}, order.Keys(secret))
+	assert.Equal(t, "s3cr3t", secret["password"])
+	assert.Equal(t, 8080, secret["port"])
+
+	_, err = client.ReadKV("secret", "apps/missing", nil)
+	assert.ErrorContains(t, err, "404")
+
+	_, err = NewVaultClient(server.URL, "", "wrong").ReadKV("secret", "apps/web", nil)
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func Test_VaultClient_AppRoleLogin(t *testing.T) {
+	server := newVaultTestServer(t)
+
+	client := NewVaultClient(server.URL, "", "")
+	assert.NoError(t, client.AppRoleLogin("approle", "my-role", "my-secret"))
+	assert.Equal(t, "approle-token", client.Token)
+	secret, err := client.ReadKV("secret", "apps/web", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "web", secret["username"])
+
+	err = NewVaultClient(server.URL, "", "").AppRoleLogin("approle", "my-role", "wrong")
+	assert.ErrorContains(t, err, "invalid role or secret ID")
+}
@@ -0,0 +1,213 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/util/file"
+	"github.com/Masterminds/sprig/v3"
+)
+
+// pepperFuncs are all template functions that read the hash pepper from the values
+var pepperFuncs = map[string]bool{
+	"MKPasswd":        true,
+	"HtpasswdBcrypt":  true,
+	"HtpasswdSHA":     true,
+	"HtpasswdAPR1":    true,
+	"Argon2id":        true,
+	"Scrypt":          true,
+	"PBKDF2":          true,
+	"ScramSHA256":     true,
+	"MosquittoPasswd": true,
+}
+
+// keyGenerators are all template functions generating a keypair under the value path given as their first argument
+var keyGenerators = map[string]string{
+	"SSHKeypair":       "ssh",
+	"WireGuardKeypair": "wireguard",
+}
+
+// stdinTemplate keeps the template read from STDIN, since it can only be read once
+var stdinTemplate *string
+
+func readTemplate(filename string) string {
+	if filename != "/dev/stdin" {
+		return file.Read(filename)
+	}
+	if stdinTemplate == nil {
+		content := file.Read(filename)
+		stdinTemplate = &content
+	}
+	return *stdinTemplate
+}
+
+// TemplateKeys returns all value paths used by a single template file,
+// or all=true if the template uses the values in a way that can't be analyzed, for example "{{{ ToYAML . }}}"
+func TemplateKeys(inputFile string) ([][]string, bool) {
+	a := newAnalyzer()
+	a.analyze(readTemplate(inputFile))
+	return a.result()
+}
+
+// SourceTemplateKeys returns all value paths used by any template in 'plato.source'
+func SourceTemplateKeys() ([][]string, bool) {
+	a, err := analyzeSource()
+	if err != nil {
+		return nil, true
+	}
+	return a.result()
+}
+
+// SourceGeneratedKeys returns the value paths of all keypairs generated by any template in 'plato.source',
+// together with the type of keypair, "ssh" or "wireguard"
+func SourceGeneratedKeys() (map[string]string, error) {
+	a, err := analyzeSource()
+	if err != nil {
+		return nil, err
+	}
+	return a.generated, nil
+}
+
+func analyzeSource() (*analyzer, error) {
+	a := newAnalyzer()
+	err := filepath.Walk(config.DirSource(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// only look at files processFile would render as templates
+		if !info.Mode().IsRegular() || filepath.Ext(path) == ".symlink" || filepath.Ext(path) == ".sops_enc" || file.Exists(path+".symlink") {
+			return nil
+		}
+		a.analyze(readTemplate(path))
+		return nil
+	})
+	return a, err
+}
+
+type analyzer struct {
+	paths     [][]string
+	all       bool
+	generated map[string]string
+}
+
+func newAnalyzer() *analyzer {
+	return &analyzer{paths: make([][]string, 0), generated: make(map[string]string)}
+}
+
+func (a *analyzer) result() ([][]string, bool) {
+	return a.paths, a.all
+}
+
+func (a *analyzer) add(path []string) {
+	a.paths = append(a.paths, slices.Clone(path))
+}
+
+func (a *analyzer) analyze(content string) {
+	tmpl, err := template.New("analyze").Funcs(funcMap).Funcs(template.FuncMap{"filepath": func() string { return "" }}).
+		Funcs(sprig.FuncMap()).Delims(config.DelimiterLeft(), config.DelimiterRight()).Parse(content)
+	if err != nil {
+		a.all = true // rendering will report the actual error
+		return
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Root == nil {
+			continue
+		}
+		// dot and $ of defined templates are whatever gets passed to them, that is analyzed at the call site
+		main := t.Name() == "analyze"
+		a.node(t.Root, main, main)
+	}
+}
+
+// node walks through a parse tree node, root tells if dot currently is the whole payload and dollar if $ is
+func (a *analyzer) node(node parse.Node, root, dollar bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, item := range n.Nodes {
+			a.node(item, root, dollar)
+		}
+	case *parse.ActionNode:
+		a.pipe(n.Pipe, root, dollar)
+	case *parse.IfNode:
+		a.pipe(n.Pipe, root, dollar)
+		a.node(n.List, root, dollar)
+		a.node(n.ElseList, root, dollar)
+	case *parse.WithNode:
+		a.pipe(n.Pipe, root, dollar)
+		a.node(n.List, false, dollar) // dot is now the result of the pipeline
+		a.node(n.ElseList, root, dollar)
+	case *parse.RangeNode:
+		a.pipe(n.Pipe, root, dollar)
+		a.node(n.List, false, dollar)
+		a.node(n.ElseList, root, dollar)
+	case *parse.TemplateNode:
+		a.pipe(n.Pipe, root, dollar)
+	}
+}
+
+func (a *analyzer) pipe(pipe *parse.PipeNode, root, dollar bool) {
+	if pipe == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		a.generator(cmd)
+		for _, arg := range cmd.Args {
+			a.arg(arg, root, dollar)
+		}
+	}
+}
+
+// generator remembers keypairs generated with a literal value path, like {{{ SSHKeypair "ssh.deploy" }}}
+func (a *analyzer) generator(cmd *parse.CommandNode) {
+	if len(cmd.Args) < 2 {
+		return
+	}
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return
+	}
+	name, ok := cmd.Args[1].(*parse.StringNode)
+	if kind, generator := keyGenerators[ident.Ident]; generator && ok {
+		a.generated[config.JoinPath(config.SplitPath(strings.TrimSpace(name.Text)))] = kind
+	}
+}
+
+func (a *analyzer) arg(arg parse.Node, root, dollar bool) {
+	switch n := arg.(type) {
+	case *parse.FieldNode:
+		if root {
+			a.add(n.Ident)
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && dollar {
+			if len(n.Ident) > 1 {
+				a.add(n.Ident[1:])
+			} else {
+				a.all = true
+			}
+		}
+	case *parse.DotNode:
+		if root {
+			a.all = true
+		}
+	case *parse.ChainNode:
+		a.arg(n.Node, root, dollar)
+	case *parse.PipeNode:
+		a.pipe(n, root, dollar)
+	case *parse.IdentifierNode:
+		if pepperFuncs[n.Ident] {
+			a.add(config.SplitPath(config.HashPepper()))
+		}
+	case *parse.StringNode:
+		// strings could be value paths, like for SSHKeypair "ssh.deploy"
+		a.add(config.SplitPath(strings.TrimSpace(n.Text)))
+	}
+}
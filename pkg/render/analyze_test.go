@@ -0,0 +1,50 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func analyzeTemplate(content string) ([][]string, bool) {
+	a := newAnalyzer()
+	a.analyze(content)
+	return a.result()
+}
+
+func Test_analyzer(t *testing.T) {
+	paths, all := analyzeTemplate(`{{{ .registry.hostname }}}:{{{ .registry.port }}}
+{{{ with .kubernetes }}}{{{ .server }}}{{{ $.ssh.public_key }}}{{{ end }}}
+{{{ range $i, $host := .hosts }}}{{{ $host.name }}}{{{ end }}}
+{{{ (SSHKeypair "deploy\\.key").public_key }}}
+{{{ MKPasswd .password }}}`)
+	assert.False(t, all)
+	assert.ElementsMatch(t, [][]string{
+		{"registry", "hostname"},
+		{"registry", "port"},
+		{"kubernetes"},
+		{"ssh", "public_key"},
+		{"hosts"},
+		{"deploy.key"},
+		{"password"},
+		{"hash_pepper"},
+	}, paths)
+
+	// the whole payload is used
+	for _, content := range []string{
+		`{{{ ToYAML . }}}`,
+		`{{{ $ | toJson }}}`,
+		`{{{ index . "ssh" }}}`,
+		`{{{ range $key, $value := . }}}{{{ $key }}}{{{ end }}}`,
+		`{{{ define "sub" }}}{{{ .x }}}{{{ end }}}{{{ template "sub" . }}}`,
+		`{{{ .unclosed`,
+	} {
+		_, all := analyzeTemplate(content)
+		assert.True(t, all, content)
+	}
+
+	// dot and $ within defined templates are whatever has been passed in
+	paths, all = analyzeTemplate(`{{{ define "sub" }}}{{{ .x }}}{{{ $.y }}}{{{ end }}}{{{ template "sub" .kubernetes }}}`)
+	assert.False(t, all)
+	assert.Equal(t, [][]string{{"kubernetes"}}, paths)
+}
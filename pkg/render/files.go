@@ -0,0 +1,152 @@
+package render
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/util/dir"
+)
+
+// assetPath resolves the given path relative to the assets directory,
+// returning it relative to the project root. It refuses any path outside of the project root
+func assetPath(path string) (string, error) {
+	pwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	base := config.DirAssets()
+	if filepath.IsAbs(base) {
+		if base, err = filepath.Rel(pwd, base); err != nil {
+			return "", err
+		}
+	}
+
+	relative := filepath.Clean(filepath.Join(base, path))
+	if filepath.IsAbs(path) || relative == ".." || strings.HasPrefix(relative, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("path [%s] is outside of the project root", path)
+	}
+	return relative, nil
+}
+
+// openRoot opens the project root, through which all file access happens.
+// Symlinks pointing outside of it are rejected by os.Root
+func openRoot() (*os.Root, error) {
+	pwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenRoot(pwd)
+}
+
+func readAsset(path string) ([]byte, error) {
+	relative, err := assetPath(path)
+	if err != nil {
+		return nil, err
+	}
+	root, err := openRoot()
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	f, err := root.Open(relative)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file [%s]: %v", path, err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func readFile(path string) (string, error) {
+	data, err := readAsset(path)
+	return string(data), err
+}
+
+func readFileBase64(path string) (string, error) {
+	data, err := readAsset(path)
+	return base64.StdEncoding.EncodeToString(data), err
+}
+
+// glob returns all paths matching the pattern, relative to the assets directory
+func glob(pattern string) ([]string, error) {
+	relative, err := assetPath(pattern)
+	if err != nil {
+		return nil, err
+	}
+	base, err := assetPath(".")
+	if err != nil {
+		return nil, err
+	}
+	root, err := openRoot()
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	matches, err := fs.Glob(root.FS(), filepath.ToSlash(relative))
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern [%s]: %v", pattern, err)
+	}
+	paths := make([]string, 0, len(matches))
+	for _, match := range matches {
+		path, err := filepath.Rel(base, filepath.FromSlash(match))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// filesIn returns all files of a directory, with their name, filename, path and content.
+// Listing and reading both happen through the project root, so symlinks can't point outside of it at any time
+func filesIn(path string) ([]dir.File, error) {
+	relative, err := assetPath(path)
+	if err != nil {
+		return nil, err
+	}
+	root, err := openRoot()
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	info, err := root.Stat(relative)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory [%s]: %v", path, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("[%s] is not a directory", path)
+	}
+	entries, err := fs.ReadDir(root.FS(), filepath.ToSlash(relative))
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory [%s]: %v", path, err)
+	}
+
+	files := make([]dir.File, 0, len(entries))
+	for _, entry := range entries {
+		filename := filepath.Join(relative, entry.Name())
+		if info, err := root.Stat(filename); err != nil {
+			return nil, fmt.Errorf("could not read file [%s]: %v", filepath.Join(path, entry.Name()), err)
+		} else if info.IsDir() {
+			continue
+		}
+		data, err := fs.ReadFile(root.FS(), filepath.ToSlash(filename))
+		if err != nil {
+			return nil, fmt.Errorf("could not read file [%s]: %v", filepath.Join(path, entry.Name()), err)
+		}
+		files = append(files, dir.File{
+			Name:     strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())),
+			Filename: entry.Name(),
+			File:     filename,
+			Content:  strings.Trim(string(data), "\n"),
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,70 @@
+package render
+
+import (
+	"encoding/base64"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_readFile(t *testing.T) {
+	data, err := readFile("secrets/test.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "This is a test file!\n:)\n", data)
+
+	data, err = readFileBase64("secrets/test.md")
+	assert.NoError(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("This is a test file!\n:)\n")), data)
+
+	// symlinks within the project root are fine
+	data, err = readFile("infrastructure/terraform/kubernetes.kubeconfig")
+	assert.NoError(t, err)
+	assert.Contains(t, data, "kubeconfig")
+
+	_, err = readFile("secrets/does-not-exist")
+	assert.Error(t, err)
+	_, err = readFile("../../main.go")
+	assert.Error(t, err)
+	_, err = readFile("/etc/passwd")
+	assert.Error(t, err)
+}
+
+func Test_readFile_symlink_escape(t *testing.T) {
+	_ = os.MkdirAll("input/tmp", 0700)
+	t.Cleanup(func() { _ = os.RemoveAll("input/tmp") })
+	assert.NoError(t, os.Symlink("../../../main.go", "input/tmp/escape"))
+
+	_, err := readFile("tmp/escape")
+	assert.Error(t, err)
+	_, err = filesIn("tmp")
+	assert.Error(t, err)
+}
+
+func Test_glob(t *testing.T) {
+	paths, err := glob("secrets/ssh.*")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secrets/ssh.private_key", "secrets/ssh.public_key"}, paths)
+
+	paths, err = glob("nothing/*")
+	assert.NoError(t, err)
+	assert.Empty(t, paths)
+
+	_, err = glob("../../*")
+	assert.Error(t, err)
+}
+
+func Test_filesIn(t *testing.T) {
+	files, err := filesIn("secrets")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(files))
+	assert.Equal(t, "test", files[3].Name)
+	assert.Equal(t, "test.md", files[3].Filename)
+	assert.Equal(t, "input/secrets/test.md", files[3].File)
+	assert.Equal(t, "This is a test file!\n:)", files[3].Content)
+
+	_, err = filesIn("secrets/test.md")
+	assert.Error(t, err)
+	_, err = filesIn("../..")
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+package render
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/store"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// generatedKeypairs caches keypairs generated during this run, so that
+// the private and public key templates of the same keypair always match
+var generatedKeypairs = make(map[string]map[string]string)
+
+// sshKeypair returns the SSH keypair stored under the given value path,
+// or generates a new Ed25519 keypair if there is none stored yet.
+// The result contains "private_key" and "public_key", rendering them into 'plato.secrets'
+// allows store-secrets to persist a newly generated keypair back into secrets.yaml
+func sshKeypair(name string, comment ...string) (map[string]string, error) {
+	if keypair, ok := generatedKeypairs[name]; ok {
+		return keypair, nil
+	}
+
+	// use existing private key if present, derive its public key with the same comment it has been generated with
+	if privateKey := config.LookupString(name + ".private_key"); len(privateKey) > 0 {
+		publicKey, err := sshPublicKey(privateKey, comment...)
+		if err != nil {
+			return nil, fmt.Errorf("could not derive public key of [%s]: %v", name, err)
+		}
+		return map[string]string{"private_key": privateKey, "public_key": publicKey}, nil
+	}
+
+	keypair, err := newSSHKeypair(comment...)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("generated new SSH keypair [%s]", color.Magenta(name))
+	store.Taint(fmt.Sprintf("generated new SSH keypair [%s]", name))
+	generatedKeypairs[name] = keypair
+	return keypair, nil
+}
+
+// newSSHKeypair generates a new Ed25519 keypair, with "private_key" in OpenSSH format and "public_key" in authorized_keys format
+func newSSHKeypair(comment ...string) (map[string]string, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate ed25519 key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, strings.Join(comment, " "))
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal ed25519 key: %v", err)
+	}
+	publicKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal ed25519 public key: %v", err)
+	}
+	return map[string]string{
+		"private_key": string(pem.EncodeToMemory(block)),
+		"public_key":  authorizedKey(publicKey, comment...),
+	}, nil
+}
+
+// sshPublicKey derives the public key in authorized_keys format from an OpenSSH/PEM private key
+func sshPublicKey(privateKey string, comment ...string) (string, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return "", fmt.Errorf("could not parse private key: %v", err)
+	}
+	return authorizedKey(signer.PublicKey(), comment...), nil
+}
+
+// sshFingerprint returns the SHA256 fingerprint of either a private key or an authorized_keys public key
+func sshFingerprint(key string) (string, error) {
+	if signer, err := ssh.ParsePrivateKey([]byte(key)); err == nil {
+		return ssh.FingerprintSHA256(signer.PublicKey()), nil
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return "", fmt.Errorf("could not parse key: %v", err)
+	}
+	return ssh.FingerprintSHA256(publicKey), nil
+}
+
+func authorizedKey(publicKey ssh.PublicKey, comment ...string) string {
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))
+	if len(comment) > 0 && len(strings.Join(comment, " ")) > 0 {
+		line = fmt.Sprintf("%s %s", line, strings.Join(comment, " "))
+	}
+	return line + "\n"
+}
+
+// wireguardKeypair returns the WireGuard keypair stored under the given value path,
+// or generates a new X25519 keypair if there is none stored yet
+func wireguardKeypair(name string) (map[string]string, error) {
+	if keypair, ok := generatedKeypairs[name]; ok {
+		return keypair, nil
+	}
+
+	// use existing private key if present, derive its public key
+	if privateKey := config.LookupString(name + ".private_key"); len(privateKey) > 0 {
+		publicKey, err := wireguardPublicKey(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not derive public key of [%s]: %v", name, err)
+		}
+		return map[string]string{"private_key": privateKey, "public_key": publicKey}, nil
+	}
+
+	keypair, err := newWireguardKeypair()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("generated new WireGuard keypair [%s]", color.Magenta(name))
+	store.Taint(fmt.Sprintf("generated new WireGuard keypair [%s]", name))
+	generatedKeypairs[name] = keypair
+	return keypair, nil
+}
+
+// newWireguardKeypair generates a new X25519 keypair, both keys base64 encoded
+func newWireguardKeypair() (map[string]string, error) {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, fmt.Errorf("could not generate x25519 key: %v", err)
+	}
+	// clamp private key, same as "wg genkey" does
+	seed[0] &= 248
+	seed[31] = (seed[31] & 127) | 64
+
+	privateKey := base64.StdEncoding.EncodeToString(seed)
+	publicKey, err := wireguardPublicKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"private_key": privateKey,
+		"public_key":  publicKey,
+	}, nil
+}
+
+// wireguardPublicKey derives the base64 encoded public key from a WireGuard private key
+func wireguardPublicKey(privateKey string) (string, error) {
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(privateKey))
+	if err != nil {
+		return "", fmt.Errorf("could not decode wireguard private key: %v", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(seed)
+	if err != nil {
+		return "", fmt.Errorf("invalid wireguard private key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
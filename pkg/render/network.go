@@ -0,0 +1,278 @@
+package render
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+)
+
+// maxRangeSize is the maximum number of addresses returned by cidrRange
+const maxRangeSize = 1 << 16
+
+// ipOfCIDR returns the address at the given position within the CIDR, same as cidrHost.
+// Positions beyond the end of the CIDR are an error instead of wrapping around into the next network
+func ipOfCIDR(cidr string, pos int) (string, error) {
+	return cidrHost(cidr, pos)
+}
+
+// parsePrefix parses a CIDR and returns it masked to its network address
+func parsePrefix(cidr string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("could not parse CIDR [%s]: %v", cidr, err)
+	}
+	return prefix.Masked(), nil
+}
+
+func addrToInt(addr netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(addr.AsSlice())
+}
+
+func intToAddr(i *big.Int, ipv4 bool) netip.Addr {
+	if ipv4 {
+		return netip.AddrFrom4([4]byte(i.FillBytes(make([]byte, 4))))
+	}
+	return netip.AddrFrom16([16]byte(i.FillBytes(make([]byte, 16))))
+}
+
+// prefixSize returns the number of addresses within the prefix
+func prefixSize(prefix netip.Prefix) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(prefix.Addr().BitLen()-prefix.Bits()))
+}
+
+// prefixLast returns the last address within the prefix
+func prefixLast(prefix netip.Prefix) netip.Addr {
+	last := new(big.Int).Add(addrToInt(prefix.Addr()), prefixSize(prefix))
+	return intToAddr(last.Sub(last, big.NewInt(1)), prefix.Addr().Is4())
+}
+
+// offsetAddr returns the address at the given offset within the prefix, negative offsets count from the end
+func offsetAddr(prefix netip.Prefix, offset int) (netip.Addr, error) {
+	size := prefixSize(prefix)
+	pos := big.NewInt(int64(offset))
+	if offset < 0 {
+		pos.Add(pos, size)
+	}
+	if pos.Sign() < 0 || pos.Cmp(size) >= 0 {
+		return netip.Addr{}, fmt.Errorf("host number %d is out of range for CIDR [%s]", offset, prefix)
+	}
+	return intToAddr(pos.Add(pos, addrToInt(prefix.Addr())), prefix.Addr().Is4()), nil
+}
+
+// cidrSubnet calculates a subnet address within the given CIDR, same as Terraform's cidrsubnet()
+func cidrSubnet(cidr string, newbits int, netnum int) (string, error) {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	bits := prefix.Bits() + newbits
+	if newbits < 0 || bits > prefix.Addr().BitLen() {
+		return "", fmt.Errorf("cannot extend prefix of CIDR [%s] by %d bits", cidr, newbits)
+	}
+	if netnum < 0 || big.NewInt(int64(netnum)).Cmp(new(big.Int).Lsh(big.NewInt(1), uint(newbits))) >= 0 {
+		return "", fmt.Errorf("network number %d does not fit into %d bits of CIDR [%s]", netnum, newbits, cidr)
+	}
+
+	offset := new(big.Int).Lsh(big.NewInt(int64(netnum)), uint(prefix.Addr().BitLen()-bits))
+	addr := intToAddr(offset.Add(offset, addrToInt(prefix.Addr())), prefix.Addr().Is4())
+	return netip.PrefixFrom(addr, bits).String(), nil
+}
+
+// cidrSubnets allocates consecutive subnets with the given additional prefix lengths, same as Terraform's cidrsubnets()
+func cidrSubnets(cidr string, newbits ...int) ([]string, error) {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	subnets := make([]string, 0, len(newbits))
+	next := addrToInt(prefix.Addr())
+	end := new(big.Int).Add(next, prefixSize(prefix))
+	for _, nb := range newbits {
+		bits := prefix.Bits() + nb
+		if nb < 0 || bits > prefix.Addr().BitLen() {
+			return nil, fmt.Errorf("cannot extend prefix of CIDR [%s] by %d bits", cidr, nb)
+		}
+		size := new(big.Int).Lsh(big.NewInt(1), uint(prefix.Addr().BitLen()-bits))
+
+		// align start address to the size of the subnet
+		if rem := new(big.Int).Mod(next, size); rem.Sign() != 0 {
+			next.Add(next, new(big.Int).Sub(size, rem))
+		}
+		if new(big.Int).Add(next, size).Cmp(end) > 0 {
+			return nil, fmt.Errorf("not enough remaining address space in CIDR [%s] for a /%d subnet", cidr, bits)
+		}
+
+		subnets = append(subnets, netip.PrefixFrom(intToAddr(next, prefix.Addr().Is4()), bits).String())
+		next.Add(next, size)
+	}
+	return subnets, nil
+}
+
+// cidrSplit splits the given CIDR into count equally sized subnets, using the smallest prefix that fits them all
+func cidrSplit(cidr string, count int) ([]string, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("cannot split CIDR [%s] into %d subnets", cidr, count)
+	}
+	newbits := 0
+	for 1<<newbits < count {
+		newbits++
+	}
+
+	bits := make([]int, count)
+	for i := range bits {
+		bits[i] = newbits
+	}
+	return cidrSubnets(cidr, bits...)
+}
+
+// cidrHost returns the address of the given host number within the CIDR, negative numbers count from the end
+func cidrHost(cidr string, hostnum int) (string, error) {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	addr, err := offsetAddr(prefix, hostnum)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// cidrRange returns all addresses from host number "from" up to and including "to" within the CIDR,
+// negative numbers count from the end
+func cidrRange(cidr string, from int, to int) ([]string, error) {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return nil, err
+	}
+	first, err := offsetAddr(prefix, from)
+	if err != nil {
+		return nil, err
+	}
+	last, err := offsetAddr(prefix, to)
+	if err != nil {
+		return nil, err
+	}
+	if last.Less(first) {
+		return nil, fmt.Errorf("invalid range %d-%d for CIDR [%s]", from, to, cidr)
+	}
+	if count := new(big.Int).Sub(addrToInt(last), addrToInt(first)); count.Cmp(big.NewInt(maxRangeSize)) >= 0 {
+		return nil, fmt.Errorf("range %d-%d for CIDR [%s] has more than %d addresses", from, to, cidr, maxRangeSize)
+	}
+
+	addrs := make([]string, 0)
+	for addr := first; ; addr = addr.Next() {
+		addrs = append(addrs, addr.String())
+		if addr == last {
+			return addrs, nil
+		}
+	}
+}
+
+func cidrNetmask(cidr string) (string, error) {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	mask := net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen())
+	addr, _ := netip.AddrFromSlice(mask)
+	return addr.String(), nil
+}
+
+func cidrBroadcast(cidr string) (string, error) {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	if !prefix.Addr().Is4() {
+		return "", fmt.Errorf("CIDR [%s] is not IPv4, IPv6 has no broadcast address", cidr)
+	}
+	return prefixLast(prefix).String(), nil
+}
+
+// cidrFirstHost returns the first usable host address, skipping the network address (the subnet-router anycast
+// address for IPv6) unless the CIDR has at most two addresses, an IPv4 /31 or /32 or an IPv6 /127 or /128
+func cidrFirstHost(cidr string) (string, error) {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	if prefix.Addr().BitLen()-prefix.Bits() <= 1 {
+		return prefix.Addr().String(), nil
+	}
+	return prefix.Addr().Next().String(), nil
+}
+
+// cidrLastHost returns the last usable host address, skipping the IPv4 broadcast address unless it's a /31 or /32
+func cidrLastHost(cidr string) (string, error) {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	last := prefixLast(prefix)
+	if prefix.Addr().Is4() && prefix.Bits() < 31 {
+		return last.Prev().String(), nil
+	}
+	return last.String(), nil
+}
+
+// cidrHostCount returns the number of usable host addresses, consistent with cidrFirstHost and cidrLastHost.
+// IPv6 networks easily have more than fit into an int64, a /64 has 2^64-1 hosts
+func cidrHostCount(cidr string) (*big.Int, error) {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return nil, err
+	}
+	count := prefixSize(prefix)
+	switch {
+	case prefix.Addr().Is4() && prefix.Bits() < 31:
+		count.Sub(count, big.NewInt(2)) // network and broadcast address
+	case !prefix.Addr().Is4() && prefix.Bits() < 127:
+		count.Sub(count, big.NewInt(1)) // subnet-router anycast address
+	}
+	return count, nil
+}
+
+// cidrContains checks if the given IP address or CIDR lies entirely within the CIDR
+func cidrContains(cidr string, ipOrCIDR string) (bool, error) {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return false, err
+	}
+	if addr, err := netip.ParseAddr(ipOrCIDR); err == nil {
+		return prefix.Contains(addr), nil
+	}
+	other, err := parsePrefix(ipOrCIDR)
+	if err != nil {
+		return false, err
+	}
+	return other.Bits() >= prefix.Bits() && prefix.Contains(other.Addr()), nil
+}
+
+// ipFamily returns either "IPv4" or "IPv6" for the given IP address or CIDR
+func ipFamily(ipOrCIDR string) (string, error) {
+	addr, err := netip.ParseAddr(ipOrCIDR)
+	if err != nil {
+		prefix, perr := parsePrefix(ipOrCIDR)
+		if perr != nil {
+			return "", fmt.Errorf("[%s] is neither an IP address nor a CIDR", ipOrCIDR)
+		}
+		addr = prefix.Addr()
+	}
+	if addr.Unmap().Is4() {
+		return "IPv4", nil
+	}
+	return "IPv6", nil
+}
+
+func isIPv4(ipOrCIDR string) bool {
+	family, err := ipFamily(ipOrCIDR)
+	return err == nil && family == "IPv4"
+}
+
+func isIPv6(ipOrCIDR string) bool {
+	family, err := ipFamily(ipOrCIDR)
+	return err == nil && family == "IPv6"
+}
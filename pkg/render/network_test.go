@@ -0,0 +1,150 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_cidrSubnet(t *testing.T) {
+	subnet, err := cidrSubnet("10.0.0.0/16", 8, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.2.0/24", subnet)
+	subnet, err = cidrSubnet("100.200.100.64/26", 2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "100.200.100.112/28", subnet)
+	subnet, err = cidrSubnet("fd00:fd12:3456:7890::/56", 16, 162)
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00:fd12:3456:7800:a200::/72", subnet)
+
+	_, err = cidrSubnet("10.0.0.0/16", 8, 256)
+	assert.Error(t, err)
+	_, err = cidrSubnet("10.0.0.0/16", 17, 0)
+	assert.Error(t, err)
+	_, err = cidrSubnet("not-a-cidr", 1, 0)
+	assert.Error(t, err)
+}
+
+func Test_cidrSubnets(t *testing.T) {
+	subnets, err := cidrSubnets("10.1.0.0/16", 4, 4, 8, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.1.0.0/20", "10.1.16.0/20", "10.1.32.0/24", "10.1.48.0/20"}, subnets)
+
+	subnets, err = cidrSplit("100.200.100.64/26", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"100.200.100.64/28", "100.200.100.80/28", "100.200.100.96/28"}, subnets)
+
+	_, err = cidrSubnets("10.1.0.0/24", 1, 1, 1)
+	assert.Error(t, err)
+	_, err = cidrSplit("10.1.0.0/24", 0)
+	assert.Error(t, err)
+}
+
+func Test_cidrHosts(t *testing.T) {
+	host, err := cidrHost("10.0.0.0/24", 13)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.13", host)
+	host, err = cidrHost("10.0.0.0/24", -2)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.254", host)
+	host, err = cidrHost("fd00::/64", 256)
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00::100", host)
+	_, err = cidrHost("10.0.0.0/24", 256)
+	assert.Error(t, err)
+
+	hosts, err := cidrRange("100.200.100.64/26", 10, 12)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"100.200.100.74", "100.200.100.75", "100.200.100.76"}, hosts)
+	_, err = cidrRange("100.200.100.64/26", 60, 70)
+	assert.Error(t, err)
+	hosts, err = cidrRange("100.200.100.64/26", -3, -2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"100.200.100.125", "100.200.100.126"}, hosts)
+	_, err = cidrRange("fd00::/64", 0, 1<<62)
+	assert.Error(t, err)
+	_, err = cidrRange("fd00::/64", 0, -1)
+	assert.Error(t, err)
+
+	first, err := cidrFirstHost("100.200.100.64/26")
+	assert.NoError(t, err)
+	assert.Equal(t, "100.200.100.65", first)
+	last, err := cidrLastHost("100.200.100.64/26")
+	assert.NoError(t, err)
+	assert.Equal(t, "100.200.100.126", last)
+	count, err := cidrHostCount("100.200.100.64/26")
+	assert.NoError(t, err)
+	assert.Equal(t, "62", count.String())
+
+	first, err = cidrFirstHost("10.0.0.4/31")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.4", first)
+	last, err = cidrLastHost("10.0.0.4/31")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.5", last)
+	count, err = cidrHostCount("10.0.0.4/31")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", count.String())
+
+	last, err = cidrLastHost("fd00::/120")
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00::ff", last)
+	count, err = cidrHostCount("fd00::/120")
+	assert.NoError(t, err)
+	assert.Equal(t, "255", count.String())
+	count, err = cidrHostCount("fd00::/64")
+	assert.NoError(t, err)
+	assert.Equal(t, "18446744073709551615", count.String())
+
+	first, err = cidrFirstHost("fd00::/127")
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00::", first)
+	count, err = cidrHostCount("fd00::/127")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", count.String())
+}
+
+func Test_cidrNetmask_and_Broadcast(t *testing.T) {
+	mask, err := cidrNetmask("100.200.100.64/26")
+	assert.NoError(t, err)
+	assert.Equal(t, "255.255.255.192", mask)
+	mask, err = cidrNetmask("fd00::/56")
+	assert.NoError(t, err)
+	assert.Equal(t, "ffff:ffff:ffff:ff00::", mask)
+
+	broadcast, err := cidrBroadcast("100.200.100.64/26")
+	assert.NoError(t, err)
+	assert.Equal(t, "100.200.100.127", broadcast)
+	_, err = cidrBroadcast("fd00::/64")
+	assert.Error(t, err)
+}
+
+func Test_cidrContains_and_IPFamily(t *testing.T) {
+	contains, err := cidrContains("100.200.100.64/26", "100.200.100.77")
+	assert.NoError(t, err)
+	assert.True(t, contains)
+	contains, err = cidrContains("100.200.100.64/26", "100.200.100.128")
+	assert.NoError(t, err)
+	assert.False(t, contains)
+	contains, err = cidrContains("100.200.100.64/26", "100.200.100.96/27")
+	assert.NoError(t, err)
+	assert.True(t, contains)
+	contains, err = cidrContains("100.200.100.64/26", "100.200.100.0/24")
+	assert.NoError(t, err)
+	assert.False(t, contains)
+	_, err = cidrContains("100.200.100.64/26", "nope")
+	assert.Error(t, err)
+
+	family, err := ipFamily("100.200.100.64/26")
+	assert.NoError(t, err)
+	assert.Equal(t, "IPv4", family)
+	family, err = ipFamily("fd00::1")
+	assert.NoError(t, err)
+	assert.Equal(t, "IPv6", family)
+	_, err = ipFamily("nope")
+	assert.Error(t, err)
+	assert.True(t, isIPv4("10.0.0.1"))
+	assert.False(t, isIPv4("fd00::/64"))
+	assert.True(t, isIPv6("fd00::/64"))
+	assert.False(t, isIPv6("nope"))
+}
@@ -0,0 +1,415 @@
+package render
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/tredoe/osutil/user/crypt/apr1_crypt"
+	"github.com/tredoe/osutil/user/crypt/common"
+	"github.com/tredoe/osutil/user/crypt/sha512_crypt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/blowfish"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	mkpasswdRounds = 8192
+
+	argon2Time      = 3
+	argon2Memory    = 64 * 1024
+	argon2Threads   = 4
+	scryptN         = 32768
+	scryptR         = 8
+	scryptP         = 1
+	pbkdf2Rounds    = 600000
+	scramRounds     = 4096
+	mosquittoRounds = 101
+)
+
+var (
+	// hashPattern matches password hashes that might be reused from a previously rendered file
+	hashPattern = regexp.MustCompile(strings.Join([]string{
+		`\$6\$(rounds=[0-9]+\$)?[./0-9A-Za-z]{1,16}\$[./0-9A-Za-z]{86}`,
+		`\$2[aby]\$[0-9]{2}\$[./0-9A-Za-z]{53}`,
+		`\$argon2id\$v=[0-9]+\$m=[0-9]+,t=[0-9]+,p=[0-9]+\$[+/0-9A-Za-z]+\$[+/0-9A-Za-z]+`,
+		`\$scrypt\$ln=[0-9]+,r=[0-9]+,p=[0-9]+\$[+/0-9A-Za-z]+\$[+/0-9A-Za-z]+`,
+		`pbkdf2_sha256\$[0-9]+\$[0-9a-f]+\$[+/0-9A-Za-z]+=*`,
+		`SCRAM-SHA-256\$[0-9]+:[+/0-9A-Za-z]+=*\$[+/0-9A-Za-z]+=*:[+/0-9A-Za-z]+=*`,
+		`\$7\$[0-9]+\$[+/0-9A-Za-z]+=*\$[+/0-9A-Za-z]+=*`,
+		`\$apr1\$[./0-9A-Za-z]{1,8}\$[./0-9A-Za-z]{22}`,
+	}, "|"))
+	// previousHashes contains all password hashes found in previously rendered files, by filename
+	previousHashes = make(map[string][]string)
+	// renderTarget is the file currently being rendered
+	renderTarget string
+	// hashPositions counts the hashes of each scheme generated so far for renderTarget
+	hashPositions = make(map[string]int)
+	// bcryptEncoding is the non-standard base64 alphabet used by bcrypt
+	bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+)
+
+// rememberHashes collects all password hashes of an already rendered file,
+// so they can be reused if they still verify against the same password
+func rememberHashes(filename string) {
+	if !config.StableHashes() {
+		return
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	if hashes := hashPattern.FindAllString(string(data), -1); len(hashes) > 0 {
+		previousHashes[filepath.Clean(filename)] = hashes
+	}
+}
+
+// startRender sets the file currently being rendered, hashes are reused from its previous render
+func startRender(targetFile string) {
+	renderTarget = targetFile
+	hashPositions = make(map[string]int)
+}
+
+// previousHash returns the hash of the previously rendered target file at the same position as the one to be generated,
+// if it verifies against the password. The n-th hash of a scheme is only ever compared with the n-th previous hash
+// of that scheme, verifying is as expensive as hashing, so there is only a single candidate to verify
+func previousHash(scheme string, verify func(hash string) bool) (string, bool) {
+	position := hashPositions[scheme]
+	hashPositions[scheme]++
+	if !config.StableHashes() {
+		return "", false
+	}
+	for _, hash := range previousHashes[filepath.Clean(renderTarget)] {
+		if !strings.HasPrefix(hash, scheme) {
+			continue
+		}
+		if position > 0 {
+			position--
+			continue
+		}
+		if verify(hash) {
+			return hash, true
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// stableSalt derives a salt from the password and the pepper stored in secrets,
+// the name is used to get different salts for the same password.
+// Without a pepper there is no stable salt, a salt derived from the password only would be the same everywhere
+func stableSalt(password, name string) ([]byte, error) {
+	var pepper string
+	value, _ := config.Lookup(config.HashPepper())
+	switch value := value.(type) {
+	case string:
+		pepper = value
+	case map[string]any:
+		pepper, _ = value[name].(string)
+	}
+	if len(pepper) == 0 {
+		return nil, fmt.Errorf("no pepper found under [%s], stable password hashes need one, "+
+			"store a random value there with \"plato secrets set %s\" or disable 'plato.stable_hashes'", config.HashPepper(), config.HashPepper())
+	}
+
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return mac.Sum(nil), nil
+}
+
+// mkpasswd returns a SHA-512 crypt hash as used in /etc/shadow. There is no yescrypt implementation,
+// but every system defaulting to yescrypt (libxcrypt) still accepts SHA-512 crypt hashes
+func mkpasswd(password string, name ...string) string {
+	c := sha512_crypt.New()
+
+	if hash, ok := previousHash("$6$", func(hash string) bool {
+		return c.Verify(hash, []byte(password)) == nil
+	}); ok {
+		return hash
+	}
+
+	var salt []byte
+	if config.StableHashes() {
+		stable, err := stableSalt(password, strings.Join(name, "."))
+		if err != nil {
+			log.Fatalf("could not generate a hashed password: %v", err)
+		}
+		salt = []byte(fmt.Sprintf("$6$rounds=%d$%s", mkpasswdRounds, common.Base64_24Bit(stable[:12])))
+	} else {
+		s := sha512_crypt.GetSalt()
+		salt = s.GenerateWRounds(s.SaltLenMax, mkpasswdRounds)
+	}
+	hash, err := c.Generate([]byte(password), salt)
+	if err != nil {
+		log.Fatalf("could not generate a hashed password with salt [%s]: %v", salt, err)
+	}
+
+	return hash
+}
+
+func htpasswdBcrypt(username string, password string) string {
+	if hash, ok := previousHash("$2", func(hash string) bool {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}); ok {
+		return fmt.Sprintf("%s:%s", username, hash)
+	}
+
+	var hash []byte
+	var err error
+	if config.StableHashes() {
+		var salt []byte
+		if salt, err = stableSalt(password, username); err == nil {
+			hash, err = bcryptWithSalt([]byte(password), bcrypt.DefaultCost, salt[:16])
+		}
+	} else {
+		hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	}
+	if err != nil {
+		log.Fatalf("failed to encrypt string with bcrypt: %s", err)
+	}
+
+	return fmt.Sprintf("%s:%s", username, string(hash))
+}
+
+// bcryptWithSalt is the same as bcrypt.GenerateFromPassword, but uses the given 16 byte salt instead of a random one
+func bcryptWithSalt(password []byte, cost int, salt []byte) ([]byte, error) {
+	if len(password) > 72 {
+		return nil, bcrypt.ErrPasswordTooLong
+	}
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, bcrypt.InvalidCostError(cost)
+	}
+
+	// trailing NULL in the key, for compatibility with C bcrypt implementations
+	key := append(password[:len(password):len(password)], 0)
+	c, err := blowfish.NewSaltedCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		blowfish.ExpandKey(key, c)
+		blowfish.ExpandKey(salt, c)
+	}
+
+	cipherData := []byte("OrpheanBeholderScryDoubt")
+	for i := 0; i < 24; i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(cipherData[i:i+8], cipherData[i:i+8])
+		}
+	}
+	// only 23 of the 24 encrypted bytes are encoded, again for compatibility with C bcrypt implementations
+	return []byte(fmt.Sprintf("$2a$%02d$%s%s", cost, bcryptEncoding.EncodeToString(salt), bcryptEncoding.EncodeToString(cipherData[:23]))), nil
+}
+
+func htpasswdSHA(username string, password string) string {
+	s := sha1.New()
+	s.Write([]byte(password))
+	passwordSum := []byte(s.Sum(nil))
+	hash := base64.StdEncoding.EncodeToString(passwordSum)
+
+	return fmt.Sprintf("%s:{SHA}%s", username, hash)
+}
+
+// newSalt returns a salt of the given size, derived from password and name if stable hashes are enabled
+func newSalt(password, name string, size int) ([]byte, error) {
+	if config.StableHashes() {
+		salt, err := stableSalt(password, name)
+		if err != nil {
+			return nil, err
+		}
+		return salt[:size], nil
+	}
+	salt := make([]byte, size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("could not generate salt: %v", err)
+	}
+	return salt, nil
+}
+
+// saltedHash reuses the previously rendered hash of the scheme at the same position, if hashing the password again
+// with its salt and the current cost parameters gives the same hash, otherwise the password is hashed with a new salt
+func saltedHash(password, name, scheme string, size int, saltOf func(hash string) ([]byte, bool), hash func(salt []byte) (string, error)) (string, error) {
+	if previous, ok := previousHash(scheme, func(previous string) bool {
+		salt, ok := saltOf(previous)
+		if !ok {
+			return false
+		}
+		rehashed, err := hash(salt)
+		return err == nil && subtle.ConstantTimeCompare([]byte(rehashed), []byte(previous)) == 1
+	}); ok {
+		return previous, nil
+	}
+
+	salt, err := newSalt(password, name, size)
+	if err != nil {
+		return "", err
+	}
+	return hash(salt)
+}
+
+// hashField decodes the salt found in the given field of a hash split by its separators
+func hashField(hash, prefix, separators string, field int, decode func(string) ([]byte, error)) ([]byte, bool) {
+	if !strings.HasPrefix(hash, prefix) {
+		return nil, false
+	}
+	fields := strings.FieldsFunc(strings.TrimPrefix(hash, prefix), func(r rune) bool { return strings.ContainsRune(separators, r) })
+	if field >= len(fields) {
+		return nil, false
+	}
+	salt, err := decode(fields[field])
+	return salt, err == nil && len(salt) > 0
+}
+
+// costParams fills in the default cost parameters for those not given in the template
+func costParams(name string, params []int, defaults ...int) ([]int, error) {
+	if len(params) > len(defaults) {
+		return nil, fmt.Errorf("%s takes at most %d cost parameters, got %d", name, len(defaults), len(params))
+	}
+	for i, param := range params {
+		if param <= 0 {
+			return nil, fmt.Errorf("%s cost parameter #%d must be positive, got %d", name, i+1, param)
+		}
+		defaults[i] = param
+	}
+	return defaults, nil
+}
+
+// argon2id returns an argon2id hash in PHC string format, optional cost parameters are time, memory (in KiB) and threads.
+// The name, e.g. the username or value path of the password, is used to get different salts for the same password
+func argon2id(name string, password string, params ...int) (string, error) {
+	p, err := costParams("Argon2id", params, argon2Time, argon2Memory, argon2Threads)
+	if err != nil {
+		return "", err
+	}
+	if p[2] > 255 {
+		return "", fmt.Errorf("argon2id threads must be between 1 and 255, got %d", p[2])
+	}
+
+	return saltedHash(password, name, "$argon2id$", 16, func(hash string) ([]byte, bool) {
+		return hashField(hash, "$argon2id$", "$", 2, base64.RawStdEncoding.DecodeString)
+	}, func(salt []byte) (string, error) {
+		key := argon2.IDKey([]byte(password), salt, uint32(p[0]), uint32(p[1]), uint8(p[2]), 32)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p[1], p[0], p[2],
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	})
+}
+
+// scryptHash returns a scrypt hash in PHC string format, optional cost parameters are N, r and p
+func scryptHash(name string, password string, params ...int) (string, error) {
+	p, err := costParams("Scrypt", params, scryptN, scryptR, scryptP)
+	if err != nil {
+		return "", err
+	}
+	if p[0] < 2 || p[0]&(p[0]-1) != 0 {
+		return "", fmt.Errorf("scrypt N must be a power of 2 greater than 1, got %d", p[0])
+	}
+
+	return saltedHash(password, name, "$scrypt$", 16, func(hash string) ([]byte, bool) {
+		return hashField(hash, "$scrypt$", "$", 1, base64.RawStdEncoding.DecodeString)
+	}, func(salt []byte) (string, error) {
+		key, err := scrypt.Key([]byte(password), salt, p[0], p[1], p[2], 32)
+		if err != nil {
+			return "", fmt.Errorf("could not generate scrypt hash: %v", err)
+		}
+		return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", bits.TrailingZeros(uint(p[0])), p[1], p[2],
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	})
+}
+
+// pbkdf2SHA256 returns a PBKDF2-SHA256 hash in Django format, optional cost parameter is the number of iterations
+func pbkdf2SHA256(name string, password string, params ...int) (string, error) {
+	p, err := costParams("PBKDF2", params, pbkdf2Rounds)
+	if err != nil {
+		return "", err
+	}
+
+	return saltedHash(password, name, "pbkdf2_sha256$", 12, func(hash string) ([]byte, bool) {
+		return hashField(hash, "pbkdf2_sha256$", "$", 1, hex.DecodeString)
+	}, func(salt []byte) (string, error) {
+		encodedSalt := hex.EncodeToString(salt)
+		key := pbkdf2.Key([]byte(password), []byte(encodedSalt), p[0], sha256.Size, sha256.New)
+		return fmt.Sprintf("pbkdf2_sha256$%d$%s$%s", p[0], encodedSalt, base64.StdEncoding.EncodeToString(key)), nil
+	})
+}
+
+// scramSHA256 returns a SCRAM-SHA-256 verifier as used by PostgreSQL, optional cost parameter is the number of iterations.
+// The name should be the role the verifier is for
+func scramSHA256(name string, password string, params ...int) (string, error) {
+	p, err := costParams("ScramSHA256", params, scramRounds)
+	if err != nil {
+		return "", err
+	}
+
+	return saltedHash(password, name, "SCRAM-SHA-256$", 16, func(hash string) ([]byte, bool) {
+		return hashField(hash, "SCRAM-SHA-256$", "$:", 1, base64.StdEncoding.DecodeString)
+	}, func(salt []byte) (string, error) {
+		saltedPassword := pbkdf2.Key([]byte(password), salt, p[0], sha256.Size, sha256.New)
+		clientKey := hmacSHA256(saltedPassword, "Client Key")
+		storedKey := sha256.Sum256(clientKey)
+		serverKey := hmacSHA256(saltedPassword, "Server Key")
+		return fmt.Sprintf("SCRAM-SHA-256$%d:%s$%s:%s", p[0], base64.StdEncoding.EncodeToString(salt),
+			base64.StdEncoding.EncodeToString(storedKey[:]), base64.StdEncoding.EncodeToString(serverKey)), nil
+	})
+}
+
+func hmacSHA256(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// mosquittoPasswd returns a mosquitto_passwd line using PBKDF2-SHA512, optional cost parameter is the number of iterations
+func mosquittoPasswd(username string, password string, params ...int) (string, error) {
+	p, err := costParams("MosquittoPasswd", params, mosquittoRounds)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := saltedHash(password, username, "$7$", 12, func(hash string) ([]byte, bool) {
+		return hashField(hash, "$7$", "$", 1, base64.StdEncoding.DecodeString)
+	}, func(salt []byte) (string, error) {
+		key := pbkdf2.Key([]byte(password), salt, p[0], sha512.Size, sha512.New)
+		return fmt.Sprintf("$7$%d$%s$%s", p[0], base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(key)), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s", username, hash), nil
+}
+
+// htpasswdAPR1 returns an htpasswd line using the Apache specific MD5 algorithm
+func htpasswdAPR1(username string, password string) (string, error) {
+	c := apr1_crypt.New()
+	if hash, ok := previousHash(apr1_crypt.MagicPrefix, func(hash string) bool {
+		return c.Verify(hash, []byte(password)) == nil
+	}); ok {
+		return fmt.Sprintf("%s:%s", username, hash), nil
+	}
+
+	salt, err := newSalt(password, username, 6)
+	if err != nil {
+		return "", err
+	}
+	hash, err := c.Generate([]byte(password), []byte(apr1_crypt.MagicPrefix+string(common.Base64_24Bit(salt))))
+	if err != nil {
+		return "", fmt.Errorf("could not generate apr1 hash: %v", err)
+	}
+	return fmt.Sprintf("%s:%s", username, hash), nil
+}
@@ -0,0 +1,225 @@
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/util/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/tredoe/osutil/user/crypt/apr1_crypt"
+	"github.com/tredoe/osutil/user/crypt/sha512_crypt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func Test_mkpasswd_stable(t *testing.T) {
+	config.Set(config.HashPepper(), "my-pepper")
+	t.Cleanup(func() { config.Set(config.HashPepper(), nil) })
+	hash := mkpasswd("my-password")
+	assert.True(t, strings.HasPrefix(hash, "$6$rounds=8192$"))
+	assert.NoError(t, sha512_crypt.New().Verify(hash, []byte("my-password")))
+	assert.Equal(t, hash, mkpasswd("my-password"))
+	assert.NotEqual(t, hash, mkpasswd("my-password", "root"))
+	assert.NotEqual(t, hash, mkpasswd("another-password"))
+
+	// a different pepper must result in a different salt
+	config.Set(config.HashPepper(), "another-pepper")
+	assert.NotEqual(t, hash, mkpasswd("my-password"))
+
+	// without a pepper there is no stable salt
+	config.Set(config.HashPepper(), nil)
+	_, err := argon2id("app", "my-password", 1, 1024, 1)
+	assert.ErrorContains(t, err, "no pepper found under [hash_pepper]")
+	config.Set(config.HashPepper(), map[string]any{"app": "my-pepper"})
+	_, err = argon2id("other", "my-password", 1, 1024, 1)
+	assert.Error(t, err)
+	_, err = argon2id("app", "my-password", 1, 1024, 1)
+	assert.NoError(t, err)
+	config.Set(config.HashPepper(), "my-pepper")
+
+	config.Set("plato.stable_hashes", false)
+	t.Cleanup(func() { config.Set("plato.stable_hashes", nil) })
+	assert.NotEqual(t, mkpasswd("my-password"), mkpasswd("my-password"))
+}
+
+func Test_htpasswdBcrypt_stable(t *testing.T) {
+	config.Set(config.HashPepper(), "my-pepper")
+	t.Cleanup(func() { config.Set(config.HashPepper(), nil) })
+	line := htpasswdBcrypt("admin", "my-password")
+	assert.True(t, strings.HasPrefix(line, "admin:$2a$10$"))
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(strings.TrimPrefix(line, "admin:")), []byte("my-password")))
+	assert.Equal(t, line, htpasswdBcrypt("admin", "my-password"))
+	assert.NotEqual(t, line, htpasswdBcrypt("user", "my-password"))
+
+	_, err := bcryptWithSalt([]byte(strings.Repeat("x", 73)), bcrypt.DefaultCost, make([]byte, 16))
+	assert.ErrorIs(t, err, bcrypt.ErrPasswordTooLong)
+}
+
+func Test_bcryptWithSalt_known_answers(t *testing.T) {
+	for _, password := range []string{"", "my-password", strings.Repeat("x", 72)} {
+		expected, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		assert.NoError(t, err)
+		salt, err := bcryptEncoding.DecodeString(string(expected[7:29]))
+		assert.NoError(t, err)
+
+		// the same salt must result in exactly the hash of golang.org/x/crypto/bcrypt
+		hash, err := bcryptWithSalt([]byte(password), bcrypt.MinCost, salt)
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), string(hash))
+		assert.NoError(t, bcrypt.CompareHashAndPassword(hash, []byte(password)))
+	}
+}
+
+func Test_htpasswdSHA(t *testing.T) {
+	assert.Equal(t, "admin:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", htpasswdSHA("admin", "password"))
+}
+
+func Test_previousHash_reuse(t *testing.T) {
+	config.Set(config.HashPepper(), "my-pepper")
+	t.Cleanup(func() { config.Set(config.HashPepper(), nil) })
+	targetFile := filepath.Join(config.DirTarget(), "hashes/shadow")
+	_ = os.MkdirAll(filepath.Dir(targetFile), 0700)
+	t.Cleanup(func() { _ = os.RemoveAll(filepath.Dir(targetFile)) })
+
+	// hashes with a random salt from a previous render must be reused as long as they verify
+	config.Set("plato.stable_hashes", false)
+	hash := mkpasswd("my-password")
+	htpasswd := htpasswdBcrypt("admin", "my-password")
+	config.Set("plato.stable_hashes", nil)
+	file.Write(targetFile, "root:"+hash+":19000:0:99999:7:::\n"+htpasswd+"\n")
+
+	rememberHashes(targetFile)
+	startRender(targetFile)
+	t.Cleanup(func() { startRender("") })
+	assert.Equal(t, hash, mkpasswd("my-password"))
+	assert.Equal(t, htpasswd, htpasswdBcrypt("admin", "my-password"))
+	assert.NotEqual(t, hash, mkpasswd("changed-password"))
+}
+
+func Test_previousHash_position(t *testing.T) {
+	config.Set(config.HashPepper(), "my-pepper")
+	t.Cleanup(func() { config.Set(config.HashPepper(), nil) })
+	targetFile := filepath.Join(config.DirTarget(), "hashes/position")
+	_ = os.MkdirAll(filepath.Dir(targetFile), 0700)
+	t.Cleanup(func() { _ = os.RemoveAll(filepath.Dir(targetFile)) })
+
+	config.Set("plato.stable_hashes", false)
+	first, _ := pbkdf2SHA256("app", "first-password", 1000)
+	second, _ := pbkdf2SHA256("app", "second-password", 1000)
+	config.Set("plato.stable_hashes", nil)
+	file.Write(targetFile, first+"\n"+second+"\n")
+	rememberHashes(targetFile)
+	t.Cleanup(func() { startRender("") })
+
+	// each hash is only compared with the previous hash of the same scheme at the same position
+	startRender(targetFile)
+	hash, _ := pbkdf2SHA256("app", "first-password", 1000)
+	assert.Equal(t, first, hash)
+	hash, _ = pbkdf2SHA256("app", "second-password", 1000)
+	assert.Equal(t, second, hash)
+
+	startRender(targetFile)
+	hash, _ = pbkdf2SHA256("app", "second-password", 1000)
+	assert.NotEqual(t, second, hash)
+}
+
+func Test_previousHash_reuse_extended(t *testing.T) {
+	config.Set(config.HashPepper(), "my-pepper")
+	t.Cleanup(func() { config.Set(config.HashPepper(), nil) })
+	targetFile := filepath.Join(config.DirTarget(), "hashes/extended")
+	_ = os.MkdirAll(filepath.Dir(targetFile), 0700)
+	t.Cleanup(func() { _ = os.RemoveAll(filepath.Dir(targetFile)) })
+
+	config.Set("plato.stable_hashes", false)
+	hashes := make([]string, 0)
+	for _, generate := range []func() (string, error){
+		func() (string, error) { return argon2id("app", "my-password", 1, 1024, 1) },
+		func() (string, error) { return scryptHash("app", "my-password", 1024, 8, 1) },
+		func() (string, error) { return pbkdf2SHA256("app", "my-password", 1000) },
+		func() (string, error) { return scramSHA256("app", "my-password") },
+		func() (string, error) { return mosquittoPasswd("mqtt", "my-password") },
+		func() (string, error) { return htpasswdAPR1("admin", "my-password") },
+	} {
+		hash, err := generate()
+		assert.NoError(t, err)
+		hashes = append(hashes, hash)
+	}
+	config.Set("plato.stable_hashes", nil)
+	file.Write(targetFile, strings.Join(hashes, "\n")+"\n")
+
+	rememberHashes(targetFile)
+	assert.Len(t, previousHashes[filepath.Clean(targetFile)], len(hashes))
+	startRender(targetFile)
+	t.Cleanup(func() { startRender("") })
+
+	hash, _ := argon2id("app", "my-password", 1, 1024, 1)
+	assert.Equal(t, hashes[0], hash)
+	hash, _ = scryptHash("app", "my-password", 1024, 8, 1)
+	assert.Equal(t, hashes[1], hash)
+	hash, _ = pbkdf2SHA256("app", "my-password", 1000)
+	assert.Equal(t, hashes[2], hash)
+	hash, _ = scramSHA256("app", "my-password")
+	assert.Equal(t, hashes[3], hash)
+	hash, _ = mosquittoPasswd("mqtt", "my-password")
+	assert.Equal(t, hashes[4], hash)
+	hash, _ = htpasswdAPR1("admin", "my-password")
+	assert.Equal(t, hashes[5], hash)
+
+	// changed cost parameters or passwords need a new hash
+	hash, _ = pbkdf2SHA256("app", "my-password", 2000)
+	assert.NotEqual(t, hashes[2], hash)
+	hash, _ = argon2id("app", "changed-password", 1, 1024, 1)
+	assert.NotEqual(t, hashes[0], hash)
+}
+
+func Test_extended_password_hashes(t *testing.T) {
+	config.Set(config.HashPepper(), "my-pepper")
+	t.Cleanup(func() { config.Set(config.HashPepper(), nil) })
+	hash, err := argon2id("app", "my-password", 1, 1024, 1)
+	assert.NoError(t, err)
+	parts := strings.Split(hash, "$")
+	assert.Equal(t, 6, len(parts))
+	assert.Equal(t, "$argon2id$v=19$m=1024,t=1,p=1$", strings.Join(parts[:4], "$")+"$")
+	salt, _ := base64.RawStdEncoding.DecodeString(parts[4])
+	assert.Equal(t, base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("my-password"), salt, 1, 1024, 1, 32)), parts[5])
+	again, err := argon2id("app", "my-password", 1, 1024, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, again)
+	other, err := argon2id("other", "my-password", 1, 1024, 1)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+	_, err = argon2id("app", "my-password", 1, 2, 3, 4)
+	assert.Error(t, err)
+
+	hash, err = scryptHash("app", "my-password", 1024, 8, 1)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$scrypt$ln=10,r=8,p=1$"))
+	_, err = scryptHash("app", "my-password", 1000)
+	assert.Error(t, err)
+
+	hash, err = pbkdf2SHA256("app", "my-password", 1000)
+	assert.NoError(t, err)
+	parts = strings.Split(hash, "$")
+	assert.Equal(t, "pbkdf2_sha256", parts[0])
+	assert.Equal(t, "1000", parts[1])
+	assert.Equal(t, base64.StdEncoding.EncodeToString(pbkdf2.Key([]byte("my-password"), []byte(parts[2]), 1000, 32, sha256.New)), parts[3])
+
+	hash, err = scramSHA256("app", "my-password")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^SCRAM-SHA-256\$4096:[A-Za-z0-9+/=]{24}\$[A-Za-z0-9+/=]{44}:[A-Za-z0-9+/=]{44}$`, hash)
+
+	line, err := mosquittoPasswd("mqtt", "my-password")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^mqtt:\$7\$101\$[A-Za-z0-9+/=]{16}\$[A-Za-z0-9+/=]{88}$`, line)
+
+	line, err = htpasswdAPR1("admin", "my-password")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "admin:$apr1$"))
+	assert.NoError(t, apr1_crypt.New().Verify(strings.TrimPrefix(line, "admin:"), []byte("my-password")))
+}
@@ -2,18 +2,16 @@ package render
 
 import (
 	"bufio"
-	"bytes"
-	"crypto/sha1"
-	"encoding/base64"
 	"fmt"
 	"io/fs"
-	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"text/template"
 
 	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/store"
 	"github.com/JamesClonk/plato/pkg/util/color"
 	"github.com/JamesClonk/plato/pkg/util/command"
 	"github.com/JamesClonk/plato/pkg/util/dir"
@@ -21,10 +19,6 @@ import (
 	"github.com/JamesClonk/plato/pkg/util/log"
 	"github.com/Masterminds/semver/v3"
 	"github.com/Masterminds/sprig/v3"
-	"github.com/spf13/viper"
-	"github.com/tredoe/osutil/user/crypt/sha512_crypt"
-	"golang.org/x/crypto/bcrypt"
-	"gopkg.in/yaml.v3"
 )
 
 func RenderFile(inputFile, outputFile string) {
@@ -32,7 +26,7 @@ func RenderFile(inputFile, outputFile string) {
 
 	// parse and render template file
 	baseFilename := filepath.Base(inputFile)
-	if err := writeFile(baseFilename, filepath.Dir(inputFile), outputFile, viper.AllSettings()); err != nil {
+	if err := writeFile(baseFilename, filepath.Dir(inputFile), outputFile, config.Values()); err != nil {
 		log.Fatalf("could not render template file [%s]: %v", color.Magenta(baseFilename), err)
 	}
 }
@@ -41,8 +35,18 @@ func RenderTemplates(removeTerraformFiles, removeAllDirectories bool) {
 	log.Infof("preparing to render templates ...")
 
 	// fail if temporary .secrets-updated marker file / gitrepo taint exists
-	if file.Exists(".secrets-updated") {
-		log.Fatalf("[%s] marker file exists, git repository is tainted, abort!", color.Magenta(".secrets-updated"))
+	if reasons, tainted := store.TaintReasons(); tainted {
+		log.Fatalf("[%s] marker file exists, git repository is tainted, abort! %s", color.Magenta(".secrets-updated"), strings.Join(reasons, ", "))
+	}
+
+	// remember password hashes of previously rendered files, to reuse them if the password hasn't changed
+	if dir.Exists(config.DirTarget()) {
+		_ = filepath.Walk(config.DirTarget(), func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				rememberHashes(path)
+			}
+			return nil
+		})
 	}
 
 	// cleanup directories
@@ -87,6 +91,9 @@ func RenderTemplates(removeTerraformFiles, removeAllDirectories bool) {
 	if err != nil {
 		log.Fatalf("could not render template files: %v", err)
 	}
+
+	// taint the git repository if there are generated secrets that still need to be stored
+	store.TaintPending()
 }
 
 var funcMap = template.FuncMap{
@@ -97,6 +104,49 @@ var funcMap = template.FuncMap{
 	"SemverCheck":    semverCheck,
 	"HtpasswdBcrypt": htpasswdBcrypt,
 	"HtpasswdSHA":    htpasswdSHA,
+
+	"HtpasswdAPR1":    htpasswdAPR1,
+	"Argon2id":        argon2id,
+	"Scrypt":          scryptHash,
+	"PBKDF2":          pbkdf2SHA256,
+	"ScramSHA256":     scramSHA256,
+	"MosquittoPasswd": mosquittoPasswd,
+
+	"CIDRSubnet":    cidrSubnet,
+	"CIDRSubnets":   cidrSubnets,
+	"CIDRSplit":     cidrSplit,
+	"CIDRHost":      cidrHost,
+	"CIDRRange":     cidrRange,
+	"CIDRNetmask":   cidrNetmask,
+	"CIDRBroadcast": cidrBroadcast,
+	"CIDRFirstHost": cidrFirstHost,
+	"CIDRLastHost":  cidrLastHost,
+	"CIDRHostCount": cidrHostCount,
+	"CIDRContains":  cidrContains,
+	"IPFamily":      ipFamily,
+	"IsIPv4":        isIPv4,
+	"IsIPv6":        isIPv6,
+
+	"ReadFile":       readFile,
+	"ReadFileBase64": readFileBase64,
+	"Glob":           glob,
+	"FilesIn":        filesIn,
+
+	"ToJSON":       toJSON,
+	"ToTOML":       toTOML,
+	"ToHCL":        toHCL,
+	"ToINI":        toINI,
+	"ToDotenv":     toDotenv,
+	"ToProperties": toProperties,
+	"FromYAML":     fromYAML,
+	"FromJSON":     fromJSON,
+	"FromTOML":     fromTOML,
+
+	"SSHKeypair":         sshKeypair,
+	"SSHPublicKey":       sshPublicKey,
+	"SSHFingerprint":     sshFingerprint,
+	"WireGuardKeypair":   wireguardKeypair,
+	"WireGuardPublicKey": wireguardPublicKey,
 }
 
 func platoHeader() string {
@@ -123,64 +173,6 @@ func semverCheck(version string, constraint string, message ...string) bool {
 	return check
 }
 
-func ipOfCIDR(cidr string, pos int) string {
-	_, c, err := net.ParseCIDR(cidr)
-	if err != nil {
-		log.Fatalf("could not parse CIDR [%s]: %v", cidr, err)
-	}
-
-	ip := c.IP
-	for i := len(ip) - 1; i >= 0 && pos > 0; i-- {
-		sum := int(ip[i]) + pos
-		ip[i] = byte(sum & 0xff)
-		pos = sum >> 8
-	}
-	return ip.String()
-}
-
-func mkpasswd(password string) string {
-	c := sha512_crypt.New()
-	s := sha512_crypt.GetSalt()
-
-	salt := s.GenerateWRounds(s.SaltLenMax, 8192)
-	hash, err := c.Generate([]byte(password), salt)
-	if err != nil {
-		log.Fatalf("could not generate a hashed password with salt [%s]: %v", salt, err)
-	}
-
-	return hash
-}
-
-func htpasswdBcrypt(username string, password string) string {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		log.Fatalf("failed to encrypt string with bcrypt: %s", err)
-	}
-
-	return fmt.Sprintf("%s:%s", username, string(hash))
-}
-
-func htpasswdSHA(username string, password string) string {
-	s := sha1.New()
-	s.Write([]byte(password))
-	passwordSum := []byte(s.Sum(nil))
-	hash := base64.StdEncoding.EncodeToString(passwordSum)
-
-	return fmt.Sprintf("%s:{SHA}%s", username, hash)
-}
-
-func toYaml(object any, indent int) string {
-	// out, err := yaml.Marshal(object)
-	buf := bytes.Buffer{}
-	enc := yaml.NewEncoder(&buf)
-	enc.SetIndent(indent)
-	err := enc.Encode(object)
-	if err != nil {
-		log.Fatalf("could not encode yaml: %v", err)
-	}
-	return strings.TrimSpace(buf.String())
-}
-
 func processFile(path string, info os.FileInfo) error {
 	baseFilename := strings.TrimPrefix(path, config.DirSource()+string(os.PathSeparator))
 	renderedFilename := filepath.Join(config.DirTarget(), baseFilename)
@@ -227,17 +219,17 @@ func processFile(path string, info os.FileInfo) error {
 		renderedFilename = strings.TrimSuffix(renderedFilename, ".sops_enc")
 
 		log.Debugf("decrypt file [%s] into [%s]", color.Magenta(path), color.Magenta(renderedFilename))
-		data, err := command.ExecOutput([]string{"sops", "-d", path})
+		data, err := sops.Decrypt(path)
 		if err != nil {
 			log.Errorf("could not decrypt file [%s]", color.Magenta(path))
 			return err
 		}
-		file.Write(renderedFilename, data)
+		file.Write(renderedFilename, string(data))
 		return nil
 	}
 
 	// parse and render template
-	if err := writeFile(baseFilename, config.DirSource(), renderedFilename, viper.AllSettings()); err != nil {
+	if err := writeFile(baseFilename, config.DirSource(), renderedFilename, config.Values()); err != nil {
 		return fmt.Errorf("could not render [%s]: %v", color.Magenta(baseFilename), err)
 	}
 	return nil
@@ -254,6 +246,9 @@ func writeFile(baseFilename, sourcePath, targetFile string, data interface{}) er
 		if err := os.MkdirAll(filepath.Dir(targetFile), 0700); err != nil { // use mode 0700, since we are likely rendering sensitive data
 			return err
 		}
+		if _, ok := previousHashes[filepath.Clean(targetFile)]; !ok {
+			rememberHashes(targetFile)
+		}
 
 		f, err = os.Create(targetFile)
 		if err != nil {
@@ -266,13 +261,14 @@ func writeFile(baseFilename, sourcePath, targetFile string, data interface{}) er
 	w := bufio.NewWriter(f)
 	defer w.Flush()
 
+	startRender(targetFile)
 	funcMap["filepath"] = func() string {
 		return baseFilename
 	}
 	tmpl := template.New(baseFilename).Funcs(funcMap).Funcs(sprig.FuncMap()).Delims(config.DelimiterLeft(), config.DelimiterRight()).Option("missingkey=error")
 
 	// parse template
-	tmpl, err = tmpl.Parse(file.Read(filepath.Join(sourcePath, baseFilename)))
+	tmpl, err = tmpl.Parse(readTemplate(filepath.Join(sourcePath, baseFilename)))
 	if err != nil {
 		log.Errorf("could not parse template [%s]", color.Magenta(baseFilename))
 		return err
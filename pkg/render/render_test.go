@@ -275,19 +275,35 @@ func Test_processFile_with_SOPS_encrypted_file(t *testing.T) {
 }
 
 func Test_ipOfCIDR_IPv4(t *testing.T) {
-	assert.Equal(t, "10.0.0.0", ipOfCIDR("10.0.0.0/24", 0))
-	assert.Equal(t, "10.0.0.1", ipOfCIDR("10.0.0.0/24", 1))
-	assert.Equal(t, "10.0.0.13", ipOfCIDR("10.0.0.0/24", 13))
-	assert.Equal(t, "10.0.0.254", ipOfCIDR("10.0.0.0/24", 254))
-	assert.Equal(t, "10.0.0.255", ipOfCIDR("10.0.0.0/24", 255))
-	assert.Equal(t, "10.0.1.4", ipOfCIDR("10.0.0.0/20", 260))
-	assert.Equal(t, "10.0.1.47", ipOfCIDR("10.0.0.0/20", 303))
-	assert.Equal(t, "100.106.160.64", ipOfCIDR("100.106.160.64/26", 0))
-	assert.Equal(t, "100.106.160.77", ipOfCIDR("100.106.160.64/26", 13))
+	assertIPOfCIDR(t, "10.0.0.0", "10.0.0.0/24", 0)
+	assertIPOfCIDR(t, "10.0.0.1", "10.0.0.0/24", 1)
+	assertIPOfCIDR(t, "10.0.0.13", "10.0.0.0/24", 13)
+	assertIPOfCIDR(t, "10.0.0.254", "10.0.0.0/24", 254)
+	assertIPOfCIDR(t, "10.0.0.255", "10.0.0.0/24", 255)
+	assertIPOfCIDR(t, "10.0.1.4", "10.0.0.0/20", 260)
+	assertIPOfCIDR(t, "10.0.1.47", "10.0.0.0/20", 303)
+	assertIPOfCIDR(t, "100.106.160.64", "100.106.160.64/26", 0)
+	assertIPOfCIDR(t, "100.106.160.77", "100.106.160.64/26", 13)
 }
 
 func Test_ipOfCIDR_IPv6(t *testing.T) {
-	assert.Equal(t, "fd00::1", ipOfCIDR("fd00::/64", 1))
-	assert.Equal(t, "fd00::d", ipOfCIDR("fd00::/64", 13))
-	assert.Equal(t, "fd00::100", ipOfCIDR("fd00::/64", 256))
+	assertIPOfCIDR(t, "fd00::1", "fd00::/64", 1)
+	assertIPOfCIDR(t, "fd00::d", "fd00::/64", 13)
+	assertIPOfCIDR(t, "fd00::100", "fd00::/64", 256)
+}
+
+func Test_ipOfCIDR_invalid(t *testing.T) {
+	_, err := ipOfCIDR("10.0.0.0", 1)
+	assert.Error(t, err)
+	// positions past the end of the CIDR must not wrap around into the next network
+	_, err = ipOfCIDR("10.0.0.0/24", 256)
+	assert.ErrorContains(t, err, "out of range")
+	_, err = ipOfCIDR("10.0.0.0/30", 300)
+	assert.Error(t, err)
+}
+
+func assertIPOfCIDR(t *testing.T, expected string, cidr string, pos int) {
+	ip, err := ipOfCIDR(cidr, pos)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, ip)
 }
@@ -0,0 +1,218 @@
+package render
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/store"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/log"
+)
+
+const (
+	defaultPasswordLength  = 32
+	defaultCertificateDays = 365
+)
+
+var charsets = map[string]string{
+	"alphanumeric": "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789",
+	"alpha":        "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+	"numeric":      "0123456789",
+	"hex":          "0123456789abcdef",
+	"ascii":        "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!#$%&()*+,-./:;<=>?@[]^_{|}~",
+}
+
+// RotatableSecrets returns all secrets "plato rotate" can regenerate: keypairs generated by templates in 'plato.source',
+// and everything declared in 'plato.rotate', which takes precedence
+func RotatableSecrets() (map[string]config.RotationSpec, error) {
+	generated, err := SourceGeneratedKeys()
+	if err != nil {
+		return nil, err
+	}
+	declared, err := config.RotatableSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make(map[string]config.RotationSpec)
+	for key, kind := range generated {
+		specs[key] = config.RotationSpec{Type: kind}
+	}
+	for key, spec := range declared {
+		specs[config.JoinPath(config.SplitPath(key))] = spec
+	}
+	return specs, nil
+}
+
+// Rotate regenerates all rotatable secrets matching any of the patterns, re-renders all templates with the new values
+// and taints the git repository until store-secrets has stored them into secrets.yaml.
+// Secrets that have been rotated or generated before must be stored first, same as for rendering
+func Rotate(patterns []string) {
+	if reasons, tainted := store.TaintReasons(); tainted {
+		log.Fatalf("[%s] marker file exists, git repository is tainted, store the pending secrets with \"plato store-secrets\" before rotating again! %s",
+			color.Magenta(".secrets-updated"), strings.Join(reasons, ", "))
+	}
+	specs, err := RotatableSecrets()
+	if err != nil {
+		log.Fatalf("could not determine rotatable secrets: %s", color.Red("%v", err))
+	}
+	keys, err := matchRotatable(specs, patterns)
+	if err != nil {
+		log.Fatalf("invalid pattern: %s", color.Red("%v", err))
+	}
+	if len(keys) == 0 {
+		log.Fatalf("no rotatable secret matches [%s]", color.Magenta(strings.Join(patterns, ", ")))
+	}
+
+	values := make(map[string]any, len(keys))
+	for _, key := range keys {
+		value, err := generate(key, specs[key])
+		if err != nil {
+			log.Fatalf("could not rotate [%s]: %s", color.Magenta(key), color.Red("%v", err))
+		}
+		values[key] = value
+		config.Set(key, value)
+		log.Infof("rotated %s [%s]", specs[key].Type, color.Magenta(key))
+	}
+
+	// render everything again, so that all outputs depending on the rotated secrets get their new values
+	RenderTemplates(false, false)
+
+	store.SaveRotations(values, time.Now())
+	reasons := make([]string, 0, len(keys))
+	for _, key := range keys {
+		reasons = append(reasons, fmt.Sprintf("rotated secret [%s]", key))
+	}
+	store.Taint(reasons...)
+	log.Warnf("%d secret(s) have been rotated, store them with \"plato store-secrets\"", len(keys))
+}
+
+// matchRotatable returns all keys matching any of the patterns, in the syntax of path.Match
+func matchRotatable(specs map[string]config.RotationSpec, patterns []string) ([]string, error) {
+	keys := make([]string, 0)
+	for key := range specs {
+		for _, pattern := range patterns {
+			matched, err := path.Match(pattern, key)
+			if err != nil {
+				return nil, fmt.Errorf("[%s]: %v", pattern, err)
+			}
+			if matched {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// generate returns a new value for the secret according to its spec
+func generate(key string, spec config.RotationSpec) (any, error) {
+	switch spec.Type {
+	case "ssh":
+		return asValue(newSSHKeypair())
+	case "wireguard":
+		return asValue(newWireguardKeypair())
+	case "tls":
+		commonName := spec.CommonName
+		if len(commonName) == 0 {
+			parts := config.SplitPath(key)
+			commonName = parts[len(parts)-1]
+		}
+		return asValue(newCertificate(commonName, spec.Days))
+	}
+	return newPassword(spec.Length, spec.Charset)
+}
+
+// asValue converts a generated keypair into a map as it would have been read from secrets.yaml, private key first
+func asValue(keypair map[string]string, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(keypair))
+	for key := range keypair {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	slices.Reverse(keys)
+	value := make(map[string]any, len(keypair))
+	for _, key := range keys {
+		value[key] = keypair[key]
+	}
+	order.Remember(value, keys)
+	return value, nil
+}
+
+// newPassword generates a random password, charset is either the name of a predefined one or the characters to use
+func newPassword(length int, charset string) (string, error) {
+	if length <= 0 {
+		length = defaultPasswordLength
+	}
+	if len(charset) == 0 {
+		charset = "alphanumeric"
+	}
+	if predefined, ok := charsets[charset]; ok {
+		charset = predefined
+	}
+
+	chars := []rune(charset)
+	password := make([]rune, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return "", fmt.Errorf("could not generate password: %v", err)
+		}
+		password[i] = chars[n.Int64()]
+	}
+	return string(password), nil
+}
+
+// newCertificate generates a self-signed ECDSA P-256 certificate, with "certificate" and "private_key" PEM encoded
+func newCertificate(commonName string, days int) (map[string]string, error) {
+	if days <= 0 {
+		days = defaultCertificateDays
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate ecdsa key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("could not generate serial number: %v", err)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.AddDate(0, 0, days),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create certificate: %v", err)
+	}
+	privateKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal ecdsa key: %v", err)
+	}
+	return map[string]string{
+		"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})),
+		"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKey})),
+	}, nil
+}
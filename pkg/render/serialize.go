@@ -0,0 +1,773 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	bareKeyPattern    = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+	envKeyPattern     = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+// order keeps the key order of all maps parsed by FromYAML, FromJSON and FromTOML, and of generated keypairs
+var order = ordered.NewOrder()
+
+// asMap returns any kind of map as map[string]any together with its keys in their original order if known,
+// either parsed by a template function or loaded into the template payload. Copies of maps have sorted keys
+func asMap(object any) (map[string]any, []string, bool) {
+	if m, ok := object.(map[string]any); ok {
+		if order.Knows(m) {
+			return m, order.Keys(m), true
+		}
+		return m, config.Keys(m), true
+	}
+	v := reflect.ValueOf(object)
+	if v.Kind() != reflect.Map {
+		return nil, nil, false
+	}
+	m := make(map[string]any, v.Len())
+	keys := make([]string, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key := fmt.Sprintf("%v", iter.Key().Interface())
+		m[key] = iter.Value().Interface()
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return m, keys, true
+}
+
+// asList returns any kind of slice or array (except []byte) as []any
+func asList(object any) ([]any, bool) {
+	if list, ok := object.([]any); ok {
+		return list, true
+	}
+	v := reflect.ValueOf(object)
+	if (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) || v.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	list := make([]any, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list, true
+}
+
+func isMap(object any) bool {
+	_, _, ok := asMap(object)
+	return ok
+}
+
+// isTableList checks if object is a non-empty list containing only maps
+func isTableList(object any) bool {
+	list, ok := asList(object)
+	if !ok || len(list) == 0 {
+		return false
+	}
+	for _, item := range list {
+		if !isMap(item) {
+			return false
+		}
+	}
+	return true
+}
+
+func toYaml(object any, indent int) string {
+	// out, err := yaml.Marshal(object)
+	buf := bytes.Buffer{}
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(indent)
+	err := enc.Encode(object)
+	if err != nil {
+		log.Fatalf("could not encode yaml: %v", err)
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// toJSON encodes object as JSON preserving the key order of maps, an indent of 0 results in compact JSON
+func toJSON(object any, indent int) (string, error) {
+	var b strings.Builder
+	if err := writeJSON(&b, object, indent, 1); err != nil {
+		return "", fmt.Errorf("could not encode json: %v", err)
+	}
+	return b.String(), nil
+}
+
+func writeJSON(b *strings.Builder, object any, indent, depth int) error {
+	newline := func(depth int) {
+		if indent > 0 {
+			b.WriteString("\n" + strings.Repeat(" ", indent*depth))
+		}
+	}
+	separator := ":"
+	if indent > 0 {
+		separator = ": "
+	}
+
+	if m, keys, ok := asMap(object); ok {
+		if len(keys) == 0 {
+			b.WriteString("{}")
+			return nil
+		}
+		b.WriteString("{")
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			newline(depth)
+			k, err := jsonScalar(key)
+			if err != nil {
+				return err
+			}
+			b.WriteString(k + separator)
+			if err := writeJSON(b, m[key], indent, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(depth - 1)
+		b.WriteString("}")
+		return nil
+	}
+	if list, ok := asList(object); ok {
+		if len(list) == 0 {
+			b.WriteString("[]")
+			return nil
+		}
+		b.WriteString("[")
+		for i, item := range list {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			newline(depth)
+			if err := writeJSON(b, item, indent, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(depth - 1)
+		b.WriteString("]")
+		return nil
+	}
+
+	value, err := jsonScalar(object)
+	if err != nil {
+		return err
+	}
+	b.WriteString(value)
+	return nil
+}
+
+func jsonScalar(object any) (string, error) {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(object); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// quote returns a double-quoted string, using only escape sequences common to TOML, HCL and JSON
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// scalar formats numbers, booleans and times, it returns false for any other type
+func scalar(object any) (string, bool) {
+	switch v := object.(type) {
+	case bool:
+		return strconv.FormatBool(v), true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", v), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case time.Time:
+		return v.Format(time.RFC3339Nano), true
+	}
+	return "", false
+}
+
+// toTOML encodes object as TOML preserving the key order of maps, indent is used for sub-tables
+func toTOML(object any, indent int) (string, error) {
+	m, keys, ok := asMap(object)
+	if !ok {
+		return "", fmt.Errorf("could not encode toml: top-level value must be a map, got %T", object)
+	}
+	var b strings.Builder
+	if err := writeTOMLTable(&b, m, keys, nil, indent); err != nil {
+		return "", fmt.Errorf("could not encode toml: %v", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func writeTOMLTable(b *strings.Builder, m map[string]any, keys []string, path []string, indent int) error {
+	prefix := strings.Repeat(" ", indent*len(path))
+
+	// key/value pairs first, they must precede any sub-tables
+	for _, key := range keys {
+		if isMap(m[key]) || isTableList(m[key]) {
+			continue
+		}
+		value, err := tomlValue(m[key])
+		if err != nil {
+			return fmt.Errorf("key [%s]: %v", strings.Join(append(path, key), "."), err)
+		}
+		b.WriteString(prefix + tomlKey(key) + " = " + value + "\n")
+	}
+
+	for _, key := range keys {
+		tablePath := append(append([]string{}, path...), key)
+		header := make([]string, len(tablePath))
+		for i, part := range tablePath {
+			header[i] = tomlKey(part)
+		}
+		headerPrefix := strings.Repeat(" ", indent*len(path))
+
+		if sub, subKeys, ok := asMap(m[key]); ok {
+			b.WriteString("\n" + headerPrefix + "[" + strings.Join(header, ".") + "]\n")
+			if err := writeTOMLTable(b, sub, subKeys, tablePath, indent); err != nil {
+				return err
+			}
+		} else if isTableList(m[key]) {
+			list, _ := asList(m[key])
+			for _, item := range list {
+				sub, subKeys, _ := asMap(item)
+				b.WriteString("\n" + headerPrefix + "[[" + strings.Join(header, ".") + "]]\n")
+				if err := writeTOMLTable(b, sub, subKeys, tablePath, indent); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func tomlKey(key string) string {
+	if bareKeyPattern.MatchString(key) {
+		return key
+	}
+	return quote(key)
+}
+
+func tomlValue(object any) (string, error) {
+	if object == nil {
+		return "", fmt.Errorf("toml does not support null values")
+	}
+	if s, ok := object.(string); ok {
+		return quote(s), nil
+	}
+	if f, ok := object.(float64); ok && (math.IsInf(f, 0) || math.IsNaN(f)) {
+		switch {
+		case math.IsNaN(f):
+			return "nan", nil
+		case f > 0:
+			return "inf", nil
+		default:
+			return "-inf", nil
+		}
+	}
+	if value, ok := scalar(object); ok {
+		return value, nil
+	}
+	if m, keys, ok := asMap(object); ok {
+		parts := make([]string, 0, len(keys))
+		for _, key := range keys {
+			value, err := tomlValue(m[key])
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, tomlKey(key)+" = "+value)
+		}
+		if len(parts) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	}
+	if list, ok := asList(object); ok {
+		parts := make([]string, 0, len(list))
+		for _, item := range list {
+			value, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, value)
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	}
+	return quote(fmt.Sprintf("%v", object)), nil
+}
+
+// toHCL encodes object as HCL attributes, as used in *.tfvars files, preserving the key order of maps
+func toHCL(object any, indent int) (string, error) {
+	m, keys, ok := asMap(object)
+	if !ok {
+		return "", fmt.Errorf("could not encode hcl: top-level value must be a map, got %T", object)
+	}
+	if indent < 1 {
+		indent = 2
+	}
+
+	var b strings.Builder
+	for _, key := range keys {
+		if !identifierPattern.MatchString(key) {
+			return "", fmt.Errorf("could not encode hcl: [%s] is not a valid attribute name", key)
+		}
+		value, err := hclValue(m[key], indent, 1)
+		if err != nil {
+			return "", fmt.Errorf("could not encode hcl: key [%s]: %v", key, err)
+		}
+		b.WriteString(key + " = " + value + "\n")
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func hclValue(object any, indent, depth int) (string, error) {
+	prefix := strings.Repeat(" ", indent*depth)
+	closing := strings.Repeat(" ", indent*(depth-1))
+
+	if object == nil {
+		return "null", nil
+	}
+	if s, ok := object.(string); ok {
+		// escape template sequences, HCL would otherwise interpolate them
+		s = strings.ReplaceAll(s, "${", "$${")
+		s = strings.ReplaceAll(s, "%{", "%%{")
+		return quote(s), nil
+	}
+	if value, ok := scalar(object); ok {
+		if _, isTime := object.(time.Time); isTime {
+			return quote(value), nil
+		}
+		return value, nil
+	}
+	if m, keys, ok := asMap(object); ok {
+		if len(keys) == 0 {
+			return "{}", nil
+		}
+		var b strings.Builder
+		b.WriteString("{\n")
+		for _, key := range keys {
+			value, err := hclValue(m[key], indent, depth+1)
+			if err != nil {
+				return "", err
+			}
+			k := key
+			if !identifierPattern.MatchString(key) {
+				k = quote(key)
+			}
+			b.WriteString(prefix + k + " = " + value + "\n")
+		}
+		b.WriteString(closing + "}")
+		return b.String(), nil
+	}
+	if list, ok := asList(object); ok {
+		if len(list) == 0 {
+			return "[]", nil
+		}
+		var b strings.Builder
+		b.WriteString("[\n")
+		for _, item := range list {
+			value, err := hclValue(item, indent, depth+1)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(prefix + value + ",\n")
+		}
+		b.WriteString(closing + "]")
+		return b.String(), nil
+	}
+	return quote(fmt.Sprintf("%v", object)), nil
+}
+
+// toINI encodes object as INI file, nested maps become [section] and [section.subsection]
+func toINI(object any) (string, error) {
+	m, keys, ok := asMap(object)
+	if !ok {
+		return "", fmt.Errorf("could not encode ini: top-level value must be a map, got %T", object)
+	}
+	var b strings.Builder
+	if err := writeINISection(&b, m, keys, ""); err != nil {
+		return "", fmt.Errorf("could not encode ini: %v", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func writeINISection(b *strings.Builder, m map[string]any, keys []string, section string) error {
+	for _, key := range keys {
+		if err := iniKey(key); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		if isMap(m[key]) {
+			continue
+		}
+		value, err := iniValue(m[key])
+		if err != nil {
+			return fmt.Errorf("key [%s]: %v", key, err)
+		}
+		b.WriteString(key + " = " + value + "\n")
+	}
+	for _, key := range keys {
+		if sub, subKeys, ok := asMap(m[key]); ok {
+			name := key
+			if len(section) > 0 {
+				name = section + "." + key
+			}
+			b.WriteString("\n[" + name + "]\n")
+			if err := writeINISection(b, sub, subKeys, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// iniKey checks if a key can be written as an ini key or section name, there is no way to quote or escape them
+func iniKey(key string) error {
+	if len(key) == 0 || key != strings.TrimSpace(key) || strings.ContainsAny(key, "=[];#\"\r\n") {
+		return fmt.Errorf("key [%s] cannot be written to ini, it must not be empty, start or end with whitespace "+
+			"or contain any of = [ ] ; # \" or line breaks", key)
+	}
+	return nil
+}
+
+func iniValue(object any) (string, error) {
+	if list, ok := asList(object); ok {
+		parts := make([]string, 0, len(list))
+		for _, item := range list {
+			value, err := iniValue(item)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, value)
+		}
+		return strings.Join(parts, ","), nil
+	}
+
+	var value string
+	if object != nil {
+		value = fmt.Sprintf("%v", object)
+		if s, ok := scalar(object); ok {
+			value = s
+		}
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return "", fmt.Errorf("ini does not support multi-line values")
+	}
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, ";#\"") {
+		return quote(value), nil
+	}
+	return value, nil
+}
+
+// flatten returns all leaf values of object, with their nested keys joined by separator
+func flatten(object any, prefix, separator string, keys *[]string, values map[string]any) {
+	join := func(key string) string {
+		if len(prefix) == 0 {
+			return key
+		}
+		return prefix + separator + key
+	}
+
+	if m, mapKeys, ok := asMap(object); ok {
+		for _, key := range mapKeys {
+			flatten(m[key], join(key), separator, keys, values)
+		}
+		return
+	}
+	if list, ok := asList(object); ok {
+		for i, item := range list {
+			flatten(item, join(strconv.Itoa(i)), separator, keys, values)
+		}
+		return
+	}
+	*keys = append(*keys, prefix)
+	values[prefix] = object
+}
+
+func stringValue(object any) string {
+	if object == nil {
+		return ""
+	}
+	if value, ok := scalar(object); ok {
+		return value
+	}
+	return fmt.Sprintf("%v", object)
+}
+
+// toDotenv encodes object as dotenv file, nested keys are joined with "_" and invalid characters replaced by "_"
+func toDotenv(object any) (string, error) {
+	if !isMap(object) {
+		return "", fmt.Errorf("could not encode dotenv: top-level value must be a map, got %T", object)
+	}
+	keys := make([]string, 0)
+	values := make(map[string]any)
+	flatten(object, "", "_", &keys, values)
+
+	var b strings.Builder
+	for _, key := range keys {
+		value := stringValue(values[key])
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		value = strings.ReplaceAll(value, `"`, `\"`)
+		value = strings.ReplaceAll(value, "$", `\$`)
+		value = strings.ReplaceAll(value, "\r", `\r`)
+		value = strings.ReplaceAll(value, "\n", `\n`)
+		b.WriteString(envKeyPattern.ReplaceAllString(key, "_") + `="` + value + "\"\n")
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// toProperties encodes object as Java properties file, nested keys are joined with "."
+func toProperties(object any) (string, error) {
+	if !isMap(object) {
+		return "", fmt.Errorf("could not encode properties: top-level value must be a map, got %T", object)
+	}
+	keys := make([]string, 0)
+	values := make(map[string]any)
+	flatten(object, "", ".", &keys, values)
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(propertiesEscape(key, true) + "=" + propertiesEscape(stringValue(values[key]), false) + "\n")
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func propertiesEscape(s string, key bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == ' ' && (key || i == 0):
+			b.WriteString(`\ `)
+		case key && strings.ContainsRune("=:#!", r):
+			b.WriteString(`\` + string(r))
+		case !key && i == 0 && strings.ContainsRune("#!", r):
+			b.WriteString(`\` + string(r))
+		case r < 0x20 || r > 0x7e:
+			// properties files are ISO-8859-1, escape everything else
+			for _, c := range utf16Units(r) {
+				fmt.Fprintf(&b, `\u%04x`, c)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func utf16Units(r rune) []rune {
+	if r < 0x10000 {
+		return []rune{r}
+	}
+	r -= 0x10000
+	return []rune{0xd800 + (r>>10)&0x3ff, 0xdc00 + r&0x3ff}
+}
+
+func fromYAML(data string) (any, error) {
+	object, err := ordered.Unmarshal([]byte(data), order)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse yaml: %v", err)
+	}
+	return object, nil
+}
+
+func fromJSON(data string) (any, error) {
+	object, err := ordered.UnmarshalJSON([]byte(data), order)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse json: %v", err)
+	}
+	return object, nil
+}
+
+func fromTOML(data string) (any, error) {
+	object := make(map[string]any)
+	if err := toml.Unmarshal([]byte(data), &object); err != nil {
+		return nil, fmt.Errorf("could not parse toml: %v", err)
+	}
+	if err := rememberTOMLOrder([]byte(data), object); err != nil {
+		return nil, fmt.Errorf("could not parse toml: %v", err)
+	}
+	return object, nil
+}
+
+// tomlOrder collects the key order of the tables of a TOML document, which toml.Unmarshal doesn't keep
+type tomlOrder struct {
+	tables  map[uintptr]map[string]any
+	keys    map[uintptr][]string
+	current map[uintptr]int // the index of the current table of each array of tables
+}
+
+// rememberTOMLOrder walks the expressions of a TOML document in their original order,
+// and remembers the order of the keys they define within the already unmarshaled object
+func rememberTOMLOrder(data []byte, object map[string]any) error {
+	o := &tomlOrder{
+		tables:  make(map[uintptr]map[string]any),
+		keys:    make(map[uintptr][]string),
+		current: make(map[uintptr]int),
+	}
+	table := object
+	o.table(table)
+
+	parser := unstable.Parser{}
+	parser.Reset(data)
+	for parser.NextExpression() {
+		expression := parser.Expression()
+		switch expression.Kind {
+		case unstable.KeyValue:
+			o.keyValue(table, expression)
+		case unstable.Table:
+			table = o.path(object, expression.Key(), false)
+		case unstable.ArrayTable:
+			table = o.path(object, expression.Key(), true)
+		}
+	}
+	if err := parser.Error(); err != nil {
+		return err
+	}
+
+	for ptr, m := range o.tables {
+		order.Remember(m, o.keys[ptr])
+	}
+	return nil
+}
+
+func (o *tomlOrder) table(m map[string]any) uintptr {
+	ptr := reflect.ValueOf(m).Pointer()
+	o.tables[ptr] = m
+	return ptr
+}
+
+// add appends a key to the known keys of a table, if it isn't known yet
+func (o *tomlOrder) add(m map[string]any, key string) {
+	ptr := o.table(m)
+	if !slices.Contains(o.keys[ptr], key) {
+		o.keys[ptr] = append(o.keys[ptr], key)
+	}
+}
+
+// path follows a dotted key from a table and returns the table it leads to. Arrays of tables are followed
+// into their current table, unless next is set for the last key of an array table header, which starts the next one
+func (o *tomlOrder) path(m map[string]any, key unstable.Iterator, next bool) map[string]any {
+	for key.Next() {
+		name := string(key.Node().Data)
+		o.add(m, name)
+		switch value := m[name].(type) {
+		case map[string]any:
+			m = value
+		case []any:
+			ptr := reflect.ValueOf(value).Pointer()
+			index, started := o.current[ptr]
+			if next && key.IsLast() {
+				if started {
+					index++
+				}
+				o.current[ptr] = index
+			}
+			if index >= len(value) {
+				return nil
+			}
+			m, _ = value[index].(map[string]any)
+		default:
+			return nil
+		}
+		if m == nil {
+			return nil
+		}
+	}
+	o.table(m)
+	return m
+}
+
+// keyValue remembers the key of a key/value pair within a table, and the keys of any inline tables within its value
+func (o *tomlOrder) keyValue(table map[string]any, expression *unstable.Node) {
+	if table == nil {
+		return
+	}
+	key := expression.Key()
+	m := table
+	var name string
+	for key.Next() {
+		name = string(key.Node().Data)
+		o.add(m, name)
+		if key.IsLast() {
+			break
+		}
+		if m, _ = m[name].(map[string]any); m == nil {
+			return
+		}
+	}
+	o.value(m[name], expression.Value())
+}
+
+func (o *tomlOrder) value(value any, node *unstable.Node) {
+	switch node.Kind {
+	case unstable.InlineTable:
+		if m, ok := value.(map[string]any); ok {
+			o.table(m)
+			children := node.Children()
+			for children.Next() {
+				o.keyValue(m, children.Node())
+			}
+		}
+	case unstable.Array:
+		if list, ok := value.([]any); ok {
+			children := node.Children()
+			for i := 0; children.Next() && i < len(list); i++ {
+				o.value(list[i], children.Node())
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var serializeInput = `
+name: plato
+version: 2
+enabled: true
+ratio: 0.5
+empty: null
+tags: [a, b]
+database:
+  user: admin
+  password: 'p@ss"word\with$special'
+  port: 5432
+servers:
+  - host: alpha
+    ip: 10.0.0.1
+  - host: beta
+    ip: 10.0.0.2
+`
+
+func Test_toJSON(t *testing.T) {
+	object, err := fromYAML(serializeInput)
+	assert.NoError(t, err)
+
+	data, err := toJSON(object, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"plato","version":2,"enabled":true,"ratio":0.5,"empty":null,"tags":["a","b"],"database":{"user":"admin","password":"p@ss\"word\\with$special","port":5432},"servers":[{"host":"alpha","ip":"10.0.0.1"},{"host":"beta","ip":"10.0.0.2"}]}`, data)
+
+	data, err = toJSON(map[string]any{"b": []any{}, "a": map[string]any{"x": 1}}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+  "a": {
+    "x": 1
+  },
+  "b": []
+}`, data)
+
+	// JSON input must keep its key order too
+	object, err = fromJSON(`{"z": 1, "a": {"y": true, "b": "<html>"}}`)
+	assert.NoError(t, err)
+	data, err = toJSON(object, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"z":1,"a":{"y":true,"b":"<html>"}}`, data)
+
+	// valid JSON that isn't valid YAML
+	object, err = fromJSON(`{"z": 1, "a": "\/", "z": 2}`)
+	assert.NoError(t, err)
+	data, err = toJSON(object, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"z":2,"a":"/"}`, data)
+
+	// maps with the same keys don't share their key order
+	object, err = fromJSON(`{"z": 1, "a": 2}`)
+	assert.NoError(t, err)
+	data, err = toJSON(map[string]any{"a": 2, "z": 1}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":2,"z":1}`, data)
+	data, err = toJSON(object, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"z":1,"a":2}`, data)
+
+	_, err = fromJSON(`{"z": 1`)
+	assert.Error(t, err)
+}
+
+func Test_toYaml_sorted(t *testing.T) {
+	object, err := fromYAML("z: 1\na:\n  x: true\n  b: [1, 2]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "a:\n  b:\n    - 1\n    - 2\n  x: true\nz: 1", toYaml(object, 2))
+}
+
+func Test_toTOML(t *testing.T) {
+	object, err := fromYAML(serializeInput)
+	assert.NoError(t, err)
+	delete(object.(map[string]any), "empty")
+
+	data, err := toTOML(object, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `name = "plato"
+version = 2
+enabled = true
+ratio = 0.5
+tags = ["a", "b"]
+
+[database]
+user = "admin"
+password = "p@ss\"word\\with$special"
+port = 5432
+
+[[servers]]
+host = "alpha"
+ip = "10.0.0.1"
+
+[[servers]]
+host = "beta"
+ip = "10.0.0.2"`, data)
+
+	parsed, err := fromTOML(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "p@ss\"word\\with$special", parsed.(map[string]any)["database"].(map[string]any)["password"])
+	assert.Equal(t, int64(5432), parsed.(map[string]any)["database"].(map[string]any)["port"])
+	roundtrip, err := toTOML(parsed, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, data, roundtrip)
+
+	parsed, err = fromTOML("z = 1\ny.b = {d = 1, c = [{f = 1, e = 2}]}\n\n[[list]]\nb = 1\na = 2\n\n[[list]]\nd = 1\nc = 2\n\n[list.sub]\ny = 1\nx = 2\n")
+	assert.NoError(t, err)
+	data, err = toJSON(parsed, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"z":1,"y":{"b":{"d":1,"c":[{"f":1,"e":2}]}},"list":[{"b":1,"a":2},{"d":1,"c":2,"sub":{"y":1,"x":2}}]}`, data)
+
+	data, err = toTOML(map[string]any{"a": map[string]any{"b": map[string]any{"c": 1}}, "my key": "x"}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, `"my key" = "x"
+
+[a]
+
+  [a.b]
+    c = 1`, data)
+
+	_, err = toTOML(map[string]any{"a": nil}, 0)
+	assert.Error(t, err)
+	_, err = toTOML("string", 0)
+	assert.Error(t, err)
+	_, err = fromTOML("a = ")
+	assert.Error(t, err)
+}
+
+func Test_toHCL(t *testing.T) {
+	object, err := fromYAML(serializeInput)
+	assert.NoError(t, err)
+
+	data, err := toHCL(object, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, `name = "plato"
+version = 2
+enabled = true
+ratio = 0.5
+empty = null
+tags = [
+  "a",
+  "b",
+]
+database = {
+  user = "admin"
+  password = "p@ss\"word\\with$special"
+  port = 5432
+}
+servers = [
+  {
+    host = "alpha"
+    ip = "10.0.0.1"
+  },
+  {
+    host = "beta"
+    ip = "10.0.0.2"
+  },
+]`, data)
+
+	data, err = toHCL(map[string]any{"labels": map[string]any{"app.kubernetes.io/name": "${var}"}}, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, `labels = {
+    "app.kubernetes.io/name" = "$${var}"
+}`, data)
+
+	_, err = toHCL(map[string]any{"not valid": 1}, 2)
+	assert.Error(t, err)
+}
+
+func Test_toINI(t *testing.T) {
+	data, err := toINI(map[string]any{
+		"app_mode": "production",
+		"server":   map[string]any{"http_port": 3000, "domain": " padded ", "tls": map[string]any{"enabled": true}},
+		"paths":    map[string]any{"plugins": []any{"a", "b"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `app_mode = production
+
+[paths]
+plugins = a,b
+
+[server]
+domain = " padded "
+http_port = 3000
+
+[server.tls]
+enabled = true`, data)
+
+	_, err = toINI(map[string]any{"multi": "line\nvalue"})
+	assert.Error(t, err)
+	for _, key := range []string{"a=b", "[a]", "a;b", "a#b", "a\nb", " a", ""} {
+		_, err = toINI(map[string]any{key: "value"})
+		assert.Error(t, err, key)
+		_, err = toINI(map[string]any{"section": map[string]any{key: map[string]any{"a": 1}}})
+		assert.Error(t, err, key)
+	}
+}
+
+func Test_toDotenv_and_Properties(t *testing.T) {
+	object, err := fromYAML(serializeInput)
+	assert.NoError(t, err)
+
+	data, err := toDotenv(object)
+	assert.NoError(t, err)
+	assert.Equal(t, `name="plato"
+version="2"
+enabled="true"
+ratio="0.5"
+empty=""
+tags_0="a"
+tags_1="b"
+database_user="admin"
+database_password="p@ss\"word\\with\$special"
+database_port="5432"
+servers_0_host="alpha"
+servers_0_ip="10.0.0.1"
+servers_1_host="beta"
+servers_1_ip="10.0.0.2"`, data)
+
+	data, err = toProperties(map[string]any{"app": map[string]any{"greeting": "héllo\nworld", "key:with=chars": " leading"}})
+	assert.NoError(t, err)
+	assert.Equal(t, `app.greeting=h\u00e9llo\nworld
+app.key\:with\=chars=\ leading`, data)
+
+	_, err = toDotenv([]any{1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,586 @@
+package sops
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the SOPS version written into the metadata of files encrypted by the age backend
+const Version = "3.10.2"
+
+// macOnlyEncryptedInitialization is hashed first into the MAC of files with 'mac_only_encrypted', exactly like SOPS does
+var macOnlyEncryptedInitialization = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0xb, 0xb, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+
+// Age is a pure Go backend for SOPS files whose data key is encrypted with age, without needing the sops binary.
+// It supports YAML, JSON and binary files
+type Age struct {
+	// Identities to decrypt data keys with, read from SOPS_AGE_KEY, SOPS_AGE_KEY_FILE or the default sops keys.txt if empty
+	Identities []age.Identity
+}
+
+type metadata struct {
+	ShamirThreshold         int        `yaml:"shamir_threshold,omitempty"`
+	KeyGroups               []keyGroup `yaml:"key_groups,omitempty"`
+	AgeKeys                 []ageKey   `yaml:"age,omitempty"`
+	LastModified            string     `yaml:"lastmodified"`
+	MAC                     string     `yaml:"mac"`
+	UnencryptedSuffix       string     `yaml:"unencrypted_suffix,omitempty"`
+	EncryptedSuffix         string     `yaml:"encrypted_suffix,omitempty"`
+	UnencryptedRegex        string     `yaml:"unencrypted_regex,omitempty"`
+	EncryptedRegex          string     `yaml:"encrypted_regex,omitempty"`
+	UnencryptedCommentRegex string     `yaml:"unencrypted_comment_regex,omitempty"`
+	EncryptedCommentRegex   string     `yaml:"encrypted_comment_regex,omitempty"`
+	MACOnlyEncrypted        bool       `yaml:"mac_only_encrypted,omitempty"`
+	Version                 string     `yaml:"version"`
+}
+
+type keyGroup struct {
+	AgeKeys []ageKey `yaml:"age"`
+}
+
+type ageKey struct {
+	Recipient        string `yaml:"recipient"`
+	EncryptedDataKey string `yaml:"enc"`
+}
+
+// document is an opened SOPS file, with its tree of values decrypted
+type document struct {
+	root         *yaml.Node
+	metadata     metadata
+	metadataNode *yaml.Node
+	rules        *rules
+	cipher       *aesCipher
+}
+
+func (a *Age) Decrypt(filename string) ([]byte, error) {
+	doc, err := a.open(filename)
+	if err != nil {
+		return nil, err
+	}
+	if Format(filename) == "binary" {
+		data := mapValue(doc.root.Content[0], "data")
+		if data == nil || data.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("[%s] does not contain any binary data", filename)
+		}
+		return []byte(data.Value), nil
+	}
+
+	conf, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return conf.emit(filename, doc.root)
+}
+
+func (a *Age) Encrypt(filename string, plaintext []byte) ([]byte, error) {
+	conf, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	rule, err := conf.creationRule(filename)
+	if err != nil {
+		return nil, err
+	}
+	recipients := rule.recipients()
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no age recipients configured in .sops.yaml for [%s]", filename)
+	}
+
+	root, err := parsePlaintext(filename, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if mapValue(root.Content[0], "sops") != nil {
+		return nil, fmt.Errorf("[%s] is already encrypted", filename)
+	}
+
+	doc := &document{
+		root: root,
+		metadata: metadata{
+			UnencryptedSuffix:       rule.UnencryptedSuffix,
+			EncryptedSuffix:         rule.EncryptedSuffix,
+			UnencryptedRegex:        rule.UnencryptedRegex,
+			EncryptedRegex:          rule.EncryptedRegex,
+			UnencryptedCommentRegex: rule.UnencryptedCommentRegex,
+			EncryptedCommentRegex:   rule.EncryptedCommentRegex,
+			MACOnlyEncrypted:        rule.MACOnlyEncrypted,
+			Version:                 Version,
+		},
+	}
+	if len(doc.metadata.UnencryptedSuffix)+len(doc.metadata.EncryptedSuffix)+len(doc.metadata.UnencryptedRegex)+len(doc.metadata.EncryptedRegex) == 0 {
+		doc.metadata.UnencryptedSuffix = "_unencrypted" // the same default SOPS uses
+	}
+	if err := doc.metadata.check(); err != nil {
+		return nil, err
+	}
+	if doc.rules, err = doc.metadata.rules(); err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	for _, recipient := range recipients {
+		encryptedKey, err := encryptDataKey(key, recipient)
+		if err != nil {
+			return nil, fmt.Errorf("could not encrypt data key for [%s]: %v", recipient, err)
+		}
+		doc.metadata.AgeKeys = append(doc.metadata.AgeKeys, ageKey{Recipient: recipient, EncryptedDataKey: encryptedKey})
+	}
+	doc.cipher = newAESCipher(key)
+
+	if err := doc.seal(); err != nil {
+		return nil, err
+	}
+	return conf.emit(filename, doc.root)
+}
+
+func (a *Age) Update(filename string, changes ...Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	doc, err := a.open(filename)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if err := applyChange(doc.root.Content[0], change); err != nil {
+			return err
+		}
+	}
+	if err := doc.seal(); err != nil {
+		return err
+	}
+
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	data, err := conf.emit(filename, doc.root)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(filename, data, info.Mode())
+}
+
+// writeAtomic replaces a file by writing a temporary file next to it first and renaming it once it is synced to disk,
+// so the file is never left truncated or half-written if plato is interrupted or the disk is full
+func writeAtomic(filename string, data []byte, perm os.FileMode) error {
+	target, err := filepath.EvalSymlinks(filename) // replace the file a symlink points to, not the symlink itself
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nothing left to remove once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// open reads an encrypted file, decrypts all its values and verifies its MAC
+func (a *Age) open(filename string) (*document, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseTree(filename, data)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &document{root: root}
+	doc.metadataNode = removeKey(root.Content[0], "sops")
+	if doc.metadataNode == nil {
+		return nil, fmt.Errorf("[%s] is not encrypted, it contains no SOPS metadata", filename)
+	}
+	if err := doc.metadataNode.Decode(&doc.metadata); err != nil {
+		return nil, fmt.Errorf("could not read SOPS metadata of [%s]: %v", filename, err)
+	}
+	if err := doc.metadata.check(); err != nil {
+		return nil, fmt.Errorf("[%s]: %v", filename, err)
+	}
+	if doc.rules, err = doc.metadata.rules(); err != nil {
+		return nil, fmt.Errorf("[%s]: %v", filename, err)
+	}
+
+	key, err := a.dataKey(doc.metadata)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt data key of [%s]: %v", filename, err)
+	}
+	doc.cipher = newAESCipher(key)
+
+	mac, err := doc.decrypt()
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt [%s]: %v", filename, err)
+	}
+	lastModified, err := time.Parse(time.RFC3339, doc.metadata.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse lastmodified of [%s]: %v", filename, err)
+	}
+	storedMAC, err := doc.cipher.decrypt(doc.metadata.MAC, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt MAC of [%s]: %v", filename, err)
+	}
+	if storedMAC != mac {
+		return nil, fmt.Errorf("MAC mismatch, [%s] has been modified without SOPS", filename)
+	}
+	return doc, nil
+}
+
+// decrypt decrypts all values of the document in-place and returns the MAC of the plaintext values
+func (doc *document) decrypt() (string, error) {
+	mac := doc.metadata.newMAC()
+	err := walk(doc.root, nil, func(node *yaml.Node, path []string) error {
+		if node.Tag == "!!null" {
+			return nil // SOPS neither encrypts nor authenticates null values
+		}
+		encrypted := doc.rules.shouldBeEncrypted(path)
+		var value any
+		if encrypted {
+			var err error
+			if value, err = doc.cipher.decrypt(node.Value, additionalData(path)); err != nil {
+				return fmt.Errorf("value at [%s]: %v", strings.Join(path, "."), err)
+			}
+			if err := setValue(node, value, nil); err != nil {
+				return err
+			}
+		} else {
+			var err error
+			if value, err = scalarValue(node); err != nil {
+				return err
+			}
+		}
+		if !doc.metadata.MACOnlyEncrypted || encrypted {
+			mac.Write(toBytes(value))
+		}
+		return nil
+	}, func(line *string, path []string) error {
+		if !doc.rules.shouldBeEncrypted(path) {
+			return nil
+		}
+		value, err := doc.cipher.decrypt(strings.TrimPrefix(*line, "#"), additionalData(path))
+		if err != nil {
+			return nil // like SOPS, assume the comment was never encrypted in the first place
+		}
+		*line = fmt.Sprintf("#%v", value)
+		return nil
+	})
+	return fmt.Sprintf("%X", mac.Sum(nil)), err
+}
+
+// seal encrypts all values of the document in-place and updates its metadata with a new MAC
+func (doc *document) seal() error {
+	mac := doc.metadata.newMAC()
+	err := walk(doc.root, nil, func(node *yaml.Node, path []string) error {
+		value, err := scalarValue(node)
+		if err != nil || value == nil {
+			return err
+		}
+		encrypted := doc.rules.shouldBeEncrypted(path)
+		if !doc.metadata.MACOnlyEncrypted || encrypted {
+			mac.Write(toBytes(value))
+		}
+		if !encrypted {
+			return nil
+		}
+		ciphertext, err := doc.cipher.encrypt(value, additionalData(path))
+		if err != nil {
+			return fmt.Errorf("value at [%s]: %v", strings.Join(path, "."), err)
+		}
+		setString(node, ciphertext)
+		return nil
+	}, func(line *string, path []string) error {
+		if !doc.rules.shouldBeEncrypted(path) {
+			return nil
+		}
+		ciphertext, err := doc.cipher.encrypt(comment(strings.TrimPrefix(*line, "#")), additionalData(path))
+		if err != nil {
+			return err
+		}
+		*line = "#" + ciphertext
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	encryptedMAC, err := doc.cipher.encrypt(fmt.Sprintf("%X", mac.Sum(nil)), lastModified)
+	if err != nil {
+		return err
+	}
+	doc.metadata.LastModified = lastModified
+	doc.metadata.MAC = encryptedMAC
+
+	if doc.metadataNode == nil {
+		doc.metadataNode = &yaml.Node{}
+		if err := doc.metadataNode.Encode(doc.metadata); err != nil {
+			return err
+		}
+	} else {
+		if err := setPath(doc.metadataNode, []string{"lastmodified"}, lastModified, nil); err != nil {
+			return err
+		}
+		if err := setPath(doc.metadataNode, []string{"mac"}, encryptedMAC, nil); err != nil {
+			return err
+		}
+	}
+	root := doc.root.Content[0]
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "sops"}, doc.metadataNode)
+	return nil
+}
+
+// check returns an error for all SOPS features the age backend does not support
+func (m metadata) check() error {
+	if len(m.UnencryptedCommentRegex) > 0 || len(m.EncryptedCommentRegex) > 0 {
+		return fmt.Errorf("comment regexes are not supported by the age backend, use the sops CLI backend instead")
+	}
+	if m.ShamirThreshold > 1 {
+		return fmt.Errorf("shamir secret sharing is not supported by the age backend, use the sops CLI backend instead")
+	}
+	return nil
+}
+
+// rules are the encrypted/unencrypted suffix and regex rules of a document, with the regexes compiled once
+type rules struct {
+	unencryptedSuffix string
+	encryptedSuffix   string
+	unencryptedRegex  *regexp.Regexp
+	encryptedRegex    *regexp.Regexp
+}
+
+func (m metadata) rules() (*rules, error) {
+	r := &rules{unencryptedSuffix: m.UnencryptedSuffix, encryptedSuffix: m.EncryptedSuffix}
+	for _, rule := range []struct {
+		expr   string
+		target **regexp.Regexp
+	}{{m.UnencryptedRegex, &r.unencryptedRegex}, {m.EncryptedRegex, &r.encryptedRegex}} {
+		if len(rule.expr) == 0 {
+			continue
+		}
+		compiled, err := regexp.Compile(rule.expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex [%s]: %v", rule.expr, err)
+		}
+		*rule.target = compiled
+	}
+	return r, nil
+}
+
+// shouldBeEncrypted applies the encrypted/unencrypted suffix and regex rules to a path, in the same order SOPS does
+func (r *rules) shouldBeEncrypted(path []string) bool {
+	encrypted := true
+	if len(r.unencryptedSuffix) > 0 && anyMatch(path, func(key string) bool { return strings.HasSuffix(key, r.unencryptedSuffix) }) {
+		encrypted = false
+	}
+	if len(r.encryptedSuffix) > 0 {
+		encrypted = anyMatch(path, func(key string) bool { return strings.HasSuffix(key, r.encryptedSuffix) })
+	}
+	if r.unencryptedRegex != nil && anyMatch(path, r.unencryptedRegex.MatchString) {
+		encrypted = false
+	}
+	if r.encryptedRegex != nil {
+		encrypted = anyMatch(path, r.encryptedRegex.MatchString)
+	}
+	return encrypted
+}
+
+func (m metadata) newMAC() hash.Hash {
+	mac := sha512.New()
+	if m.MACOnlyEncrypted {
+		mac.Write(macOnlyEncryptedInitialization)
+	}
+	return mac
+}
+
+func (m metadata) ageKeys() []ageKey {
+	keys := m.AgeKeys
+	for _, group := range m.KeyGroups {
+		keys = append(keys, group.AgeKeys...)
+	}
+	return keys
+}
+
+func anyMatch(path []string, match func(string) bool) bool {
+	for _, key := range path {
+		if match(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// additionalData returns the authenticated data SOPS uses for a value, its path joined with colons
+func additionalData(path []string) string {
+	return strings.Join(path, ":") + ":"
+}
+
+// toBytes returns the bytes SOPS hashes into the MAC for a value
+func toBytes(value any) []byte {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return []byte("True")
+		}
+		return []byte("False")
+	case float64:
+		return []byte(formatFloat(v))
+	case time.Time:
+		data, _ := v.MarshalText()
+		return data
+	}
+	return []byte(fmt.Sprintf("%v", value))
+}
+
+// dataKey decrypts the data key of a file with the first age identity that is able to
+func (a *Age) dataKey(m metadata) ([]byte, error) {
+	keys := m.ageKeys()
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no age recipients found, only age-encrypted files are supported by the age backend")
+	}
+	identities, err := a.identities()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, key := range keys {
+		reader, err := age.Decrypt(armor.NewReader(strings.NewReader(key.EncryptedDataKey)), identities...)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return io.ReadAll(reader)
+	}
+	return nil, lastErr
+}
+
+// identities returns the configured age identities, or else reads them the same way SOPS does
+func (a *Age) identities() ([]age.Identity, error) {
+	if len(a.Identities) > 0 {
+		return a.Identities, nil
+	}
+
+	identities := make([]age.Identity, 0)
+	if key := os.Getenv("SOPS_AGE_KEY"); len(key) > 0 {
+		parsed, err := age.ParseIdentities(strings.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse SOPS_AGE_KEY: %v", err)
+		}
+		identities = append(identities, parsed...)
+	}
+
+	keyFile := os.Getenv("SOPS_AGE_KEY_FILE")
+	if len(keyFile) == 0 {
+		if configDir, err := os.UserConfigDir(); err == nil {
+			keyFile = filepath.Join(configDir, "sops", "age", "keys.txt")
+			if _, err := os.Stat(keyFile); err != nil {
+				keyFile = ""
+			}
+		}
+	}
+	if len(keyFile) > 0 {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read age keys: %v", err)
+		}
+		parsed, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse age keys of [%s]: %v", keyFile, err)
+		}
+		identities = append(identities, parsed...)
+	}
+
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no age identities found, set SOPS_AGE_KEY or SOPS_AGE_KEY_FILE")
+	}
+	return identities, nil
+}
+
+func encryptDataKey(key []byte, recipient string) (string, error) {
+	parsed, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	armored := armor.NewWriter(&buf)
+	writer, err := age.Encrypt(armored, parsed)
+	if err != nil {
+		return "", err
+	}
+	if _, err := writer.Write(key); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	if err := armored.Close(); err != nil {
+		return "", err
+	}
+	buf.WriteString("\n")
+	return buf.String(), nil
+}
+
+// parseTree parses an encrypted file, binary files are stored as JSON by SOPS
+func parseTree(filename string, data []byte) (*yaml.Node, error) {
+	switch format := Format(filename); format {
+	case "yaml", "json", "binary":
+		var root yaml.Node
+		if err := yaml.Unmarshal(data, &root); err != nil {
+			return nil, fmt.Errorf("could not parse [%s]: %v", filename, err)
+		}
+		if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("[%s] does not contain a map", filename)
+		}
+		return &root, nil
+	default:
+		return nil, fmt.Errorf("%s files are not supported by the age backend, use the sops CLI backend instead", format)
+	}
+}
+
+// parsePlaintext parses a file to be encrypted, binary files are wrapped into a map with a single "data" key like SOPS does
+func parsePlaintext(filename string, data []byte) (*yaml.Node, error) {
+	if Format(filename) == "binary" {
+		root := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+		return root, setPath(root.Content[0], []string{"data"}, string(data), nil)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}, nil
+	}
+	return parseTree(filename, data)
+}
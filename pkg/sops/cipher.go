@@ -0,0 +1,150 @@
+package sops
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// nonceSize is the IV size SOPS uses for AES-GCM
+const nonceSize = 32
+
+var encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]`)
+
+// comment is the plaintext of an encrypted YAML comment
+type comment string
+
+type stashKey struct {
+	additionalData string
+	plaintext      any
+}
+
+// aesCipher encrypts and decrypts single values with the data key of a file, in the SOPS value format
+type aesCipher struct {
+	key []byte
+	// stash keeps the IVs of decrypted values, so re-encrypting an unchanged value results in the same ciphertext
+	stash map[stashKey][]byte
+}
+
+func newAESCipher(key []byte) *aesCipher {
+	return &aesCipher{key: key, stash: make(map[stashKey][]byte)}
+}
+
+func (c *aesCipher) decrypt(value string, additionalData string) (any, error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+	matches := encryptedValue.FindStringSubmatch(value)
+	if matches == nil {
+		return nil, fmt.Errorf("not an encrypted value")
+	}
+	decoded := make([][]byte, 3)
+	for i := range decoded {
+		var err error
+		if decoded[i], err = base64.StdEncoding.DecodeString(matches[i+1]); err != nil {
+			return nil, fmt.Errorf("could not decode encrypted value: %v", err)
+		}
+	}
+	data, iv, tag := decoded[0], decoded[1], decoded[2]
+
+	gcm, err := c.gcm(len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt with AES-GCM: %v", err)
+	}
+
+	var result any
+	switch matches[4] {
+	case "str":
+		result = string(plaintext)
+	case "int":
+		result, err = strconv.Atoi(string(plaintext))
+	case "float":
+		result, err = strconv.ParseFloat(string(plaintext), 64)
+	case "bool":
+		result, err = strconv.ParseBool(string(plaintext))
+	case "bytes":
+		result = string(plaintext)
+	case "time":
+		var t time.Time
+		err = t.UnmarshalText(plaintext)
+		result = t
+	case "comment":
+		result = comment(plaintext)
+	default:
+		return nil, fmt.Errorf("unknown value type [%s]", matches[4])
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.stash[stashKey{additionalData, result}] = iv
+	return result, nil
+}
+
+func (c *aesCipher) encrypt(value any, additionalData string) (string, error) {
+	var plaintext []byte
+	var valueType string
+	switch v := value.(type) {
+	case string:
+		plaintext, valueType = []byte(v), "str"
+	case int:
+		plaintext, valueType = []byte(strconv.Itoa(v)), "int"
+	case float64:
+		plaintext, valueType = []byte(formatFloat(v)), "float"
+	case bool:
+		plaintext, valueType = []byte(strconv.FormatBool(v)), "bool"
+	case time.Time:
+		data, err := v.MarshalText()
+		if err != nil {
+			return "", err
+		}
+		plaintext, valueType = data, "time"
+	case comment:
+		plaintext, valueType = []byte(v), "comment"
+	default:
+		return "", fmt.Errorf("cannot encrypt value of type %T", value)
+	}
+	if len(plaintext) == 0 && (valueType == "str" || valueType == "comment") {
+		return "", nil // SOPS leaves empty strings as they are
+	}
+
+	iv, ok := c.stash[stashKey{additionalData, value}]
+	if !ok {
+		iv = make([]byte, nonceSize)
+		if _, err := rand.Read(iv); err != nil {
+			return "", err
+		}
+	}
+	gcm, err := c.gcm(len(iv))
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+		valueType), nil
+}
+
+func (c *aesCipher) gcm(nonceSize int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, nonceSize)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
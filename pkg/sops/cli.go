@@ -0,0 +1,198 @@
+package sops
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/util/command"
+	"github.com/JamesClonk/plato/pkg/util/log"
+)
+
+// fileHasNotBeenModified is the exit code of "sops edit" if the file content did not change
+const fileHasNotBeenModified = 200
+
+// CLI is the backend shelling out to the sops binary
+type CLI struct{}
+
+func (CLI) Decrypt(filename string) ([]byte, error) {
+	// stdout only, sops might print warnings on stderr that must not end up in the decrypted content
+	stdout, stderr, err := command.ExecSeparateOutput(command.Get([]string{"sops", "-d", filename}))
+	if err != nil {
+		return nil, cliError(err, stderr)
+	}
+	return []byte(stdout), nil
+}
+
+func (CLI) Encrypt(filename string, plaintext []byte) ([]byte, error) {
+	format := Format(filename)
+	cmd := command.Get([]string{"sops", "encrypt", "--filename-override", filename, "--input-type", format, "--output-type", format})
+	cmd.Stdin = bytes.NewReader(plaintext)
+	stdout, stderr, err := command.ExecSeparateOutput(cmd)
+	if err != nil {
+		return nil, cliError(err, stderr)
+	}
+	return []byte(stdout), nil
+}
+
+// Update decrypts the file once, applies all changes and lets "sops edit" encrypt it again, which keeps the data key
+// and the ciphertext of all unchanged values. A single scalar change is simply set with "sops --set".
+// The updated plaintext, and the one "sops edit" writes itself, only exist in a private temporary directory
+// for the duration of the update, which is on tmpfs unless there is none (see PrivateTempDir)
+func (c CLI) Update(filename string, changes ...Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	if len(changes) == 1 && changes[0].Delete {
+		return c.unset(filename, changes[0].Path)
+	}
+	if len(changes) == 1 && isScalar(changes[0].Value) {
+		return c.set(filename, changes[0].Path, changes[0].Value)
+	}
+
+	plaintext, err := c.Decrypt(filename)
+	if err != nil {
+		return err
+	}
+	root, err := parseTree(filename, plaintext)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if err := applyChange(root.Content[0], change); err != nil {
+			return err
+		}
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	updated, err := conf.emit(filename, root)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(updated, plaintext) {
+		return nil // sops edit would refuse to re-encrypt an unchanged file
+	}
+
+	tmpDir, err := PrivateTempDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpFile := filepath.Join(tmpDir, "updated"+filepath.Ext(filename))
+	if err := os.WriteFile(tmpFile, updated, 0600); err != nil {
+		return err
+	}
+
+	// the "editor" just replaces the decrypted content sops hands it with the updated one,
+	// sops writes that decrypted content into TMPDIR, so it goes into the private directory too
+	editor := fmt.Sprintf("cp '%s'", tmpFile)
+	cmd := command.Get([]string{"sops", "edit", filename})
+	cmd.Env = append(os.Environ(), "SOPS_EDITOR="+editor, "EDITOR="+editor, "TMPDIR="+tmpDir)
+	_, stderr, err := command.ExecSeparateOutput(cmd)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == fileHasNotBeenModified {
+		return nil
+	}
+	if err != nil {
+		return cliError(err, stderr)
+	}
+	return nil
+}
+
+func (CLI) set(filename string, path []string, value any) error {
+	if len(path) == 0 {
+		return fmt.Errorf("no key path given")
+	}
+	data, err := marshalJSON(value)
+	if err != nil {
+		return err
+	}
+	index, err := treeIndex(path)
+	if err != nil {
+		return err
+	}
+
+	_, stderr, err := command.ExecSeparateOutput(command.Get([]string{"sops", "--set", index + " " + string(data), filename}))
+	if err != nil {
+		return cliError(err, stderr)
+	}
+	return nil
+}
+
+func (CLI) unset(filename string, path []string) error {
+	if len(path) == 0 {
+		return fmt.Errorf("no key path given")
+	}
+	index, err := treeIndex(path)
+	if err != nil {
+		return err
+	}
+
+	_, stderr, err := command.ExecSeparateOutput(command.Get([]string{"sops", "unset", "--idempotent", filename, index}))
+	if err != nil {
+		return cliError(err, stderr)
+	}
+	return nil
+}
+
+// treeIndex returns a key path in the format of "sops --set" and "sops unset",
+// see https://github.com/getsops/sops#set-a-sub-part-in-a-document-tree
+func treeIndex(path []string) (string, error) {
+	var index strings.Builder
+	for _, key := range path {
+		key, err := marshalJSON(key)
+		if err != nil {
+			return "", err
+		}
+		index.WriteString("[" + string(key) + "]")
+	}
+	return index.String(), nil
+}
+
+// isScalar checks if a value is neither a map nor a list, JSON objects would lose their key order with "sops --set"
+func isScalar(value any) bool {
+	switch value.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	return true
+}
+
+func cliError(err error, stderr string) error {
+	if len(strings.TrimSpace(stderr)) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr))
+	}
+	return err
+}
+
+// marshalJSON encodes a value as JSON without escaping HTML characters
+func marshalJSON(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// PrivateTempDir creates a temporary directory only accessible by the current user, preferably on tmpfs,
+// so that decrypted secrets never end up on disk. Without tmpfs it warns and falls back to TMPDIR
+func PrivateTempDir() (string, error) {
+	for _, parent := range []string{"/dev/shm", os.Getenv("XDG_RUNTIME_DIR")} {
+		if info, err := os.Stat(parent); err == nil && len(parent) > 0 && info.IsDir() {
+			if tmpDir, err := os.MkdirTemp(parent, "plato-"); err == nil {
+				return tmpDir, nil
+			}
+		}
+	}
+	log.Warnf("no tmpfs available, decrypted secrets are temporarily written to [%s]", os.TempDir())
+	return os.MkdirTemp("", "plato-")
+}
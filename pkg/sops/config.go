@@ -0,0 +1,218 @@
+package sops
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is the part of a .sops.yaml the age backend needs
+type config struct {
+	CreationRules []creationRule `yaml:"creation_rules"`
+	Stores        struct {
+		YAML       storeConfig `yaml:"yaml"`
+		JSON       storeConfig `yaml:"json"`
+		JSONBinary storeConfig `yaml:"json_binary"`
+	} `yaml:"stores"`
+}
+
+type storeConfig struct {
+	Indent *int `yaml:"indent"`
+}
+
+type creationRule struct {
+	PathRegex string `yaml:"path_regex"`
+	// Age and all other keys are either a comma separated string or a list
+	Age               any `yaml:"age"`
+	PGP               any `yaml:"pgp"`
+	KMS               any `yaml:"kms"`
+	GCPKMS            any `yaml:"gcp_kms"`
+	AzureKeyVault     any `yaml:"azure_keyvault"`
+	HCVaultTransitURI any `yaml:"hc_vault_transit_uri"`
+	KeyGroups         []struct {
+		Age []string `yaml:"age"`
+		PGP []string `yaml:"pgp"`
+		KMS []struct {
+			ARN string `yaml:"arn"`
+		} `yaml:"kms"`
+		GCPKMS []struct {
+			ResourceID string `yaml:"resource_id"`
+		} `yaml:"gcp_kms"`
+		AzureKeyVault []struct {
+			VaultURL string `yaml:"vaultUrl"`
+			Key      string `yaml:"key"`
+			Version  string `yaml:"version"`
+		} `yaml:"azure_keyvault"`
+		HCVault []string `yaml:"hc_vault"`
+	} `yaml:"key_groups"`
+	UnencryptedSuffix       string `yaml:"unencrypted_suffix"`
+	EncryptedSuffix         string `yaml:"encrypted_suffix"`
+	UnencryptedRegex        string `yaml:"unencrypted_regex"`
+	EncryptedRegex          string `yaml:"encrypted_regex"`
+	UnencryptedCommentRegex string `yaml:"unencrypted_comment_regex"`
+	EncryptedCommentRegex   string `yaml:"encrypted_comment_regex"`
+	MACOnlyEncrypted        bool   `yaml:"mac_only_encrypted"`
+}
+
+// loadConfig reads the nearest .sops.yaml, searching upwards from the current working directory like SOPS does
+func loadConfig() (*config, error) {
+	conf := &config{}
+	filename, err := ConfigFile()
+	if err != nil || len(filename) == 0 {
+		return conf, err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("could not parse [%s]: %v", filename, err)
+	}
+	return conf, nil
+}
+
+// ConfigFile returns the path of the nearest .sops.yaml, or an empty string if there is none
+func ConfigFile() (string, error) {
+	pwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for range 100 {
+		for _, name := range []string{".sops.yaml", ".sops.yml"} {
+			if _, err := os.Stat(filepath.Join(pwd, name)); err == nil {
+				return filepath.Join(pwd, name), nil
+			}
+		}
+		if pwd == filepath.Dir(pwd) {
+			break
+		}
+		pwd = filepath.Dir(pwd)
+	}
+	return "", nil
+}
+
+// creationRule returns the first creation rule matching a filename
+func (c *config) creationRule(filename string) (*creationRule, error) {
+	for i, rule := range c.CreationRules {
+		if len(rule.PathRegex) == 0 {
+			return &c.CreationRules[i], nil
+		}
+		expr, err := regexp.Compile(rule.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path_regex [%s] in .sops.yaml: %v", rule.PathRegex, err)
+		}
+		if expr.MatchString(filename) {
+			return &c.CreationRules[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no creation rule in .sops.yaml matches [%s]", filename)
+}
+
+func (r *creationRule) recipients() []string {
+	recipients := splitKeys(r.Age)
+	for _, group := range r.KeyGroups {
+		recipients = append(recipients, group.Age...)
+	}
+	return trimKeys(recipients)
+}
+
+// allRecipients returns the recipients of all kinds of keys, not only age
+func (r *creationRule) allRecipients() []Recipient {
+	recipients := make([]Recipient, 0)
+	add := func(kind string, keys []string) {
+		for _, key := range trimKeys(keys) {
+			recipients = append(recipients, Recipient{Type: kind, Key: key})
+		}
+	}
+	add("age", splitKeys(r.Age))
+	add("pgp", splitKeys(r.PGP))
+	add("kms", splitKeys(r.KMS))
+	add("gcp_kms", splitKeys(r.GCPKMS))
+	add("azure_kv", splitKeys(r.AzureKeyVault))
+	add("hc_vault", splitKeys(r.HCVaultTransitURI))
+	for _, group := range r.KeyGroups {
+		add("age", group.Age)
+		add("pgp", group.PGP)
+		for _, key := range group.KMS {
+			add("kms", []string{key.ARN})
+		}
+		for _, key := range group.GCPKMS {
+			add("gcp_kms", []string{key.ResourceID})
+		}
+		for _, key := range group.AzureKeyVault {
+			add("azure_kv", []string{strings.TrimSuffix(key.VaultURL, "/") + "/keys/" + key.Key + "/" + key.Version})
+		}
+		add("hc_vault", group.HCVault)
+	}
+	return recipients
+}
+
+// splitKeys returns the keys of a comma separated string or a list
+func splitKeys(value any) []string {
+	keys := make([]string, 0)
+	switch v := value.(type) {
+	case string:
+		keys = append(keys, strings.Split(v, ",")...)
+	case []any:
+		for _, key := range v {
+			keys = append(keys, fmt.Sprintf("%v", key))
+		}
+	}
+	return keys
+}
+
+func trimKeys(keys []string) []string {
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); len(key) > 0 {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// emit encodes a tree in the format of a file, with the indentation configured in .sops.yaml
+func (c *config) emit(filename string, root *yaml.Node) ([]byte, error) {
+	switch Format(filename) {
+	case "yaml":
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(indent(c.Stores.YAML, 4))
+		if err := encoder.Encode(root); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "json":
+		return c.emitJSON(root, c.Stores.JSON)
+	case "binary":
+		return c.emitJSON(root, c.Stores.JSONBinary)
+	}
+	return nil, fmt.Errorf("%s files are not supported by the age backend, use the sops CLI backend instead", Format(filename))
+}
+
+func (c *config) emitJSON(root *yaml.Node, store storeConfig) ([]byte, error) {
+	prefix := "\t"
+	if spaces := indent(store, -1); spaces >= 0 {
+		prefix = strings.Repeat(" ", spaces)
+	}
+	data, err := toJSON(root, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func indent(store storeConfig, fallback int) int {
+	if store.Indent == nil {
+		return fallback
+	}
+	return *store.Indent
+}
@@ -0,0 +1,58 @@
+package sops
+
+import (
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IsEncrypted checks if YAML or JSON data contains SOPS metadata, no matter which kind of keys
+// (age, PGP, AWS/GCP/Azure KMS or Vault transit) it is encrypted with
+func IsEncrypted(data []byte) bool {
+	var document struct {
+		SOPS *struct {
+			MAC     string `yaml:"mac"`
+			Version string `yaml:"version"`
+		} `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(data, &document); err != nil || document.SOPS == nil {
+		return false
+	}
+	return len(document.SOPS.MAC) > 0 && len(document.SOPS.Version) > 0
+}
+
+// IsEncryptedFile checks if a file contains SOPS metadata
+func IsEncryptedFile(filename string) bool {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	return IsEncrypted(data)
+}
+
+// IsEncryptedValue checks if a string is a single value encrypted by SOPS
+func IsEncryptedValue(value string) bool {
+	return encryptedValue.MatchString(value)
+}
+
+// ContainsEncrypted checks if a value is, or contains within its maps and lists, any value encrypted by SOPS.
+// Partially encrypted files, like with 'unencrypted_suffix' or 'encrypted_regex', keep all other values in plaintext
+func ContainsEncrypted(value any) bool {
+	switch v := value.(type) {
+	case string:
+		return IsEncryptedValue(v)
+	case map[string]any:
+		for _, item := range v {
+			if ContainsEncrypted(item) {
+				return true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if ContainsEncrypted(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
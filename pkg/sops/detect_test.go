@@ -0,0 +1,44 @@
+package sops
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IsEncrypted(t *testing.T) {
+	data, err := os.ReadFile("secrets.yaml")
+	assert.NoError(t, err)
+	assert.True(t, IsEncrypted(data))
+	assert.True(t, IsEncryptedFile("combined/plato.yaml"))
+	assert.True(t, IsEncryptedFile("input/infrastructure/terraform/terraform.tfstate.backup.sops_enc"))
+
+	// any kind of key
+	for _, metadata := range []string{
+		"pgp:\n    - fp: 85D77543B3D624B63CEA9E6DBC17301B491B3F21\n",
+		"kms:\n    - arn: arn:aws:kms:us-east-1:656532927350:key/920aff2e\n",
+		"gcp_kms:\n    - resource_id: projects/plato/locations/global/keyRings/sops/cryptoKeys/sops-key\n",
+		"azure_kv:\n    - vault_url: https://plato.vault.azure.net\n",
+		"hc_vault:\n    - vault_address: http://127.0.0.1:8200\n",
+	} {
+		data := "password: ENC[AES256_GCM,data:3C/n,iv:jT0I,tag:heQw,type:str]\nsops:\n  " + metadata + "  lastmodified: \"2026-04-06T08:35:54Z\"\n  mac: ENC[AES256_GCM,data:Q06V,iv:PjLg,tag:9TBh,type:str]\n  version: 3.10.2\n"
+		assert.True(t, IsEncrypted([]byte(data)), metadata)
+	}
+
+	assert.False(t, IsEncrypted([]byte("sops: no\n")))
+	assert.False(t, IsEncrypted([]byte("sops:\n  version: 3.10.2\n")))
+	assert.False(t, IsEncrypted([]byte("not: [valid")))
+	assert.False(t, IsEncryptedFile("plato.yaml"))
+	assert.False(t, IsEncryptedFile("does-not-exist.yaml"))
+}
+
+func Test_ContainsEncrypted(t *testing.T) {
+	encrypted := "ENC[AES256_GCM,data:3C/n,iv:jT0I,tag:heQw,type:str]"
+	assert.True(t, IsEncryptedValue(encrypted))
+	assert.False(t, IsEncryptedValue("ENC[plain"))
+
+	assert.True(t, ContainsEncrypted(map[string]any{"a": map[string]any{"b": []any{1, encrypted}}}))
+	assert.False(t, ContainsEncrypted(map[string]any{"a": map[string]any{"b": []any{1, "two"}}, "c_unencrypted": "visible"}))
+	assert.False(t, ContainsEncrypted(nil))
+}
@@ -0,0 +1,72 @@
+package sops
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"gopkg.in/yaml.v3"
+)
+
+// Fake is an in-memory backend for tests, "encrypting" files by just keeping their plaintext content
+type Fake struct {
+	Files map[string][]byte
+}
+
+func NewFake() *Fake {
+	return &Fake{Files: make(map[string][]byte)}
+}
+
+func (f *Fake) Decrypt(filename string) ([]byte, error) {
+	data, ok := f.Files[filename]
+	if !ok {
+		return nil, fmt.Errorf("[%s] is not encrypted", filename)
+	}
+	return slices.Clone(data), nil
+}
+
+func (f *Fake) Encrypt(filename string, plaintext []byte) ([]byte, error) {
+	f.Files[filename] = slices.Clone(plaintext)
+	return []byte(fmt.Sprintf("FAKE[%s]", filename)), nil
+}
+
+func (f *Fake) Update(filename string, changes ...Change) error {
+	document := any(make(map[string]any))
+	if data, ok := f.Files[filename]; ok && len(data) > 0 {
+		var err error
+		if document, err = ordered.Unmarshal(data, nil); err != nil {
+			return err
+		}
+	}
+
+	m, ok := document.(map[string]any)
+	if !ok {
+		return fmt.Errorf("[%s] does not contain a map", filename)
+	}
+	for _, change := range changes {
+		if len(change.Path) == 0 {
+			return fmt.Errorf("no key path given")
+		}
+		parent := m
+		for _, key := range change.Path[:len(change.Path)-1] {
+			child, ok := parent[key].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				parent[key] = child
+			}
+			parent = child
+		}
+		if change.Delete {
+			delete(parent, change.Path[len(change.Path)-1]) // might leave empty maps behind, good enough for tests
+			continue
+		}
+		parent[change.Path[len(change.Path)-1]] = change.Value
+	}
+
+	data, err := yaml.Marshal(document)
+	if err != nil {
+		return err
+	}
+	f.Files[filename] = data
+	return nil
+}
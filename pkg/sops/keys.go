@@ -0,0 +1,350 @@
+package sops
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/util/command"
+	"gopkg.in/yaml.v3"
+)
+
+// Recipient is a key the data key of a SOPS file is encrypted with.
+// Managing recipients always runs the sops binary, no matter which backend is used otherwise
+type Recipient struct {
+	Type string // age, pgp, kms, gcp_kms, azure_kv or hc_vault
+	Key  string // age recipient, PGP fingerprint, AWS KMS ARN, GCP KMS resource ID, Azure Key Vault key URL or Vault transit URI
+}
+
+// recipientTypes maps each type of recipient to its key in .sops.yaml creation rules and its "sops rotate" flag
+var recipientTypes = map[string]struct{ rule, flag string }{
+	"age":      {rule: "age", flag: "age"},
+	"pgp":      {rule: "pgp", flag: "pgp"},
+	"kms":      {rule: "kms", flag: "kms"},
+	"gcp_kms":  {rule: "gcp_kms", flag: "gcp-kms"},
+	"azure_kv": {rule: "azure_keyvault", flag: "azure-kv"},
+	"hc_vault": {rule: "hc_vault_transit_uri", flag: "hc-vault-transit"},
+}
+
+var pgpFingerprint = regexp.MustCompile(`^([0-9A-Fa-f]{16}|[0-9A-Fa-f]{40})$`)
+
+func (r Recipient) String() string {
+	return r.Type + ":" + r.Key
+}
+
+// id is used to compare recipients, PGP fingerprints are case insensitive and often written with spaces
+func (r Recipient) id() string {
+	if r.Type == "pgp" {
+		return r.Type + ":" + strings.ToUpper(strings.ReplaceAll(r.Key, " ", ""))
+	}
+	return r.Type + ":" + strings.TrimSpace(r.Key)
+}
+
+// ParseRecipient returns the recipient of the given key, its type is derived from the key itself if kind is empty
+func ParseRecipient(key, kind string) (Recipient, error) {
+	key = strings.TrimSpace(key)
+	if len(kind) > 0 {
+		if _, ok := recipientTypes[kind]; !ok {
+			return Recipient{}, fmt.Errorf("unknown recipient type [%s], must be one of age, pgp, kms, gcp_kms, azure_kv or hc_vault", kind)
+		}
+		return Recipient{Type: kind, Key: key}, nil
+	}
+
+	switch {
+	case strings.HasPrefix(key, "age1"):
+		kind = "age"
+	case strings.HasPrefix(key, "arn:"):
+		kind = "kms"
+	case strings.HasPrefix(key, "projects/") && strings.Contains(key, "/cryptoKeys/"):
+		kind = "gcp_kms"
+	case strings.Contains(key, ".vault.azure.net/keys/"):
+		kind = "azure_kv"
+	case (strings.HasPrefix(key, "https://") || strings.HasPrefix(key, "http://")) && strings.Contains(key, "/v1/"):
+		kind = "hc_vault"
+	case pgpFingerprint.MatchString(strings.ReplaceAll(key, " ", "")):
+		kind = "pgp"
+	default:
+		return Recipient{}, fmt.Errorf("can't tell the type of recipient [%s], use --type", key)
+	}
+	return Recipient{Type: kind, Key: key}, nil
+}
+
+// keyMetadata is the part of the SOPS metadata listing the keys a file is encrypted with
+type keyMetadata struct {
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+	} `yaml:"age"`
+	PGP []struct {
+		Fingerprint string `yaml:"fp"`
+	} `yaml:"pgp"`
+	KMS []struct {
+		ARN string `yaml:"arn"`
+	} `yaml:"kms"`
+	GCPKMS []struct {
+		ResourceID string `yaml:"resource_id"`
+	} `yaml:"gcp_kms"`
+	AzureKV []struct {
+		VaultURL string `yaml:"vault_url"`
+		Name     string `yaml:"name"`
+		Version  string `yaml:"version"`
+	} `yaml:"azure_kv"`
+	HCVault []struct {
+		VaultAddress string `yaml:"vault_address"`
+		EnginePath   string `yaml:"engine_path"`
+		KeyName      string `yaml:"key_name"`
+	} `yaml:"hc_vault"`
+	KeyGroups []keyMetadata `yaml:"key_groups"`
+}
+
+func (m keyMetadata) recipients() []Recipient {
+	recipients := make([]Recipient, 0)
+	for _, key := range m.Age {
+		recipients = append(recipients, Recipient{Type: "age", Key: key.Recipient})
+	}
+	for _, key := range m.PGP {
+		recipients = append(recipients, Recipient{Type: "pgp", Key: key.Fingerprint})
+	}
+	for _, key := range m.KMS {
+		recipients = append(recipients, Recipient{Type: "kms", Key: key.ARN})
+	}
+	for _, key := range m.GCPKMS {
+		recipients = append(recipients, Recipient{Type: "gcp_kms", Key: key.ResourceID})
+	}
+	for _, key := range m.AzureKV {
+		recipients = append(recipients, Recipient{Type: "azure_kv", Key: strings.TrimSuffix(key.VaultURL, "/") + "/keys/" + key.Name + "/" + key.Version})
+	}
+	for _, key := range m.HCVault {
+		recipients = append(recipients, Recipient{Type: "hc_vault", Key: strings.TrimSuffix(key.VaultAddress, "/") + "/v1/" + key.EnginePath + "/keys/" + key.KeyName})
+	}
+	for _, group := range m.KeyGroups {
+		recipients = append(recipients, group.recipients()...)
+	}
+	return recipients
+}
+
+// Recipients returns all recipients an encrypted YAML, JSON or binary file is encrypted for, read from its SOPS metadata
+func Recipients(filename string) ([]Recipient, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var document struct {
+		SOPS *keyMetadata `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(data, &document); err != nil || document.SOPS == nil {
+		return nil, fmt.Errorf("no SOPS metadata found in [%s]", filename)
+	}
+	return document.SOPS.recipients(), nil
+}
+
+// RuleRecipients returns the recipients of the creation rule in .sops.yaml matching a file, or false if there is none
+func RuleRecipients(filename string) ([]Recipient, bool, error) {
+	conf, err := loadConfig()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(conf.CreationRules) == 0 {
+		return nil, false, nil
+	}
+	rule, err := conf.creationRule(filename)
+	if err != nil {
+		return nil, false, nil
+	}
+	return rule.allRecipients(), true, nil
+}
+
+// DiffRecipients returns the recipients expected but missing, and the ones present but not expected
+func DiffRecipients(expected, actual []Recipient) (missing, extra []Recipient) {
+	contains := func(recipients []Recipient, r Recipient) bool {
+		return slices.ContainsFunc(recipients, func(other Recipient) bool { return other.id() == r.id() })
+	}
+	for _, r := range expected {
+		if !contains(actual, r) {
+			missing = append(missing, r)
+		}
+	}
+	for _, r := range actual {
+		if !contains(expected, r) {
+			extra = append(extra, r)
+		}
+	}
+	return missing, extra
+}
+
+// AddRecipient encrypts the file for an additional recipient, "sops rotate" generates a new data key while at it
+func AddRecipient(filename string, recipient Recipient) error {
+	return rotate(filename, "--add-"+recipientTypes[recipient.Type].flag, recipient.Key)
+}
+
+// RemoveRecipient removes a recipient from the file, "sops rotate" generates a new data key the recipient never had
+func RemoveRecipient(filename string, recipient Recipient) error {
+	return rotate(filename, "--rm-"+recipientTypes[recipient.Type].flag, recipient.Key)
+}
+
+// RotateDataKey generates a new data key and re-encrypts all values of the file with it
+func RotateDataKey(filename string) error {
+	return rotate(filename)
+}
+
+func rotate(filename string, args ...string) error {
+	cmd := append(append([]string{"sops", "rotate", "--in-place"}, args...), filename)
+	_, stderr, err := command.ExecSeparateOutput(command.Get(cmd))
+	if err != nil {
+		return cliError(err, stderr)
+	}
+	return nil
+}
+
+// UpdateKeys encrypts the data key of the file for exactly the recipients of its creation rule in .sops.yaml
+func UpdateKeys(filename string) error {
+	_, stderr, err := command.ExecSeparateOutput(command.Get([]string{"sops", "updatekeys", "--yes", filename}))
+	if err != nil {
+		return cliError(err, stderr)
+	}
+	return nil
+}
+
+// UpdateCreationRules adds or removes a recipient in all creation rules of .sops.yaml used by any of the files.
+// Comments and the order of all keys are kept, it returns false if there is no .sops.yaml or no rule had to be changed
+func UpdateCreationRules(files []string, recipient Recipient, add bool) (bool, error) {
+	filename, err := ConfigFile()
+	if err != nil || len(filename) == 0 {
+		return false, err
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		return false, err
+	}
+	used := make(map[int]bool)
+	for _, f := range files {
+		rule, err := conf.creationRule(f)
+		if err != nil {
+			continue // files without a rule are managed one by one
+		}
+		for i := range conf.CreationRules {
+			if rule == &conf.CreationRules[i] {
+				used[i] = true
+			}
+		}
+	}
+	if len(used) == 0 {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return false, fmt.Errorf("could not parse [%s]: %v", filename, err)
+	}
+	rules := mapValue(root.Content[0], "creation_rules")
+	changed := false
+	for i, rule := range rules.Content {
+		if !used[i] {
+			continue
+		}
+		ruleChanged, err := updateRule(rule, recipient, add)
+		if err != nil {
+			return false, fmt.Errorf("could not update creation rule %d in [%s]: %v", i, filename, err)
+		}
+		changed = changed || ruleChanged
+	}
+	if !changed {
+		return false, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return false, err
+	}
+	if err := encoder.Close(); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// updateRule adds or removes a recipient in a single creation rule, either in its key groups or its top-level keys
+func updateRule(rule *yaml.Node, recipient Recipient, add bool) (bool, error) {
+	key := recipientTypes[recipient.Type].rule
+	if groups := mapValue(rule, "key_groups"); groups != nil && len(groups.Content) > 0 {
+		if recipient.Type != "age" && recipient.Type != "pgp" && recipient.Type != "hc_vault" {
+			return false, fmt.Errorf("%s recipients in key_groups have to be edited by hand", recipient.Type)
+		}
+		if recipient.Type == "hc_vault" {
+			key = "hc_vault"
+		}
+		changed := false
+		for i, group := range groups.Content {
+			if add && i > 0 {
+				break // new recipients join the first key group
+			}
+			list := mapValue(group, key)
+			if list == nil {
+				if !add {
+					continue
+				}
+				list = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+				group.Content = append(group.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, list)
+			}
+			changed = updateList(list, recipient, add) || changed
+		}
+		return changed, nil
+	}
+
+	value := mapValue(rule, key)
+	switch {
+	case value == nil && add:
+		rule.Content = append(rule.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: recipient.Key})
+		return true, nil
+	case value == nil:
+		return false, nil
+	case value.Kind == yaml.SequenceNode:
+		return updateList(value, recipient, add), nil
+	}
+
+	// comma separated string
+	keys := trimKeys(strings.Split(value.Value, ","))
+	updated := withRecipient(keys, recipient, add)
+	if slices.Equal(keys, updated) {
+		return false, nil
+	}
+	value.Value = strings.Join(updated, ",")
+	return true, nil
+}
+
+func updateList(list *yaml.Node, recipient Recipient, add bool) bool {
+	keys := make([]string, 0, len(list.Content))
+	for _, item := range list.Content {
+		keys = append(keys, item.Value)
+	}
+	updated := withRecipient(keys, recipient, add)
+	if slices.Equal(keys, updated) {
+		return false
+	}
+	list.Content = slices.DeleteFunc(list.Content, func(item *yaml.Node) bool { return !slices.Contains(updated, item.Value) })
+	if add && len(updated) > len(keys) {
+		list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: recipient.Key})
+	}
+	return true
+}
+
+// withRecipient returns the keys with the recipient added or removed
+func withRecipient(keys []string, recipient Recipient, add bool) []string {
+	matches := func(key string) bool { return Recipient{Type: recipient.Type, Key: key}.id() == recipient.id() }
+	if add {
+		if slices.ContainsFunc(keys, matches) {
+			return keys
+		}
+		return append(slices.Clone(keys), recipient.Key)
+	}
+	return slices.DeleteFunc(slices.Clone(keys), matches)
+}
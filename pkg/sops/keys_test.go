@@ -0,0 +1,94 @@
+package sops
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+const fixtureRecipient = "age1yapc0k0tfz8cketuldrjq3vyuzne4587zmf3d2ejypaftg95yvrs8r44yh"
+
+func Test_ParseRecipient(t *testing.T) {
+	for key, kind := range map[string]string{
+		fixtureRecipient: "age",
+		"85D77543B3D624B63CEA9E6DBC17301B491B3F21":                               "pgp",
+		"85D7 7543 B3D6 24B6 3CEA 9E6D BC17 301B 491B 3F21":                      "pgp",
+		"arn:aws:kms:us-east-1:656532927350:key/920aff2e":                        "kms",
+		"projects/plato/locations/global/keyRings/sops/cryptoKeys/sops-key":      "gcp_kms",
+		"https://plato.vault.azure.net/keys/sops/e2cbd0b2cd2c4f6b9d2b0b1c1d1e1f": "azure_kv",
+		"https://vault.example.org:8200/v1/transit/keys/sops":                    "hc_vault",
+	} {
+		recipient, err := ParseRecipient(key, "")
+		assert.NoError(t, err, key)
+		assert.Equal(t, kind, recipient.Type, key)
+	}
+
+	_, err := ParseRecipient("unknown", "")
+	assert.Error(t, err)
+	recipient, err := ParseRecipient("unknown", "pgp")
+	assert.NoError(t, err)
+	assert.Equal(t, Recipient{Type: "pgp", Key: "unknown"}, recipient)
+	_, err = ParseRecipient("unknown", "ssh")
+	assert.Error(t, err)
+}
+
+func Test_Recipients(t *testing.T) {
+	expected := []Recipient{{Type: "age", Key: fixtureRecipient}}
+	for _, filename := range []string{"secrets.yaml", "input/infrastructure/terraform/terraform.tfstate.backup.sops_enc"} {
+		recipients, err := Recipients(filename)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, recipients)
+
+		rule, ok, err := RuleRecipients(filename)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, expected, rule)
+	}
+	_, err := Recipients("plato.yaml")
+	assert.Error(t, err)
+
+	missing, extra := DiffRecipients(
+		[]Recipient{{Type: "age", Key: fixtureRecipient}, {Type: "pgp", Key: "85d7 7543 b3d6 24b6"}},
+		[]Recipient{{Type: "pgp", Key: "85D77543B3D624B6"}, {Type: "kms", Key: "arn:aws:kms:key"}})
+	assert.Equal(t, []Recipient{{Type: "age", Key: fixtureRecipient}}, missing)
+	assert.Equal(t, []Recipient{{Type: "kms", Key: "arn:aws:kms:key"}}, extra)
+}
+
+func Test_updateRule(t *testing.T) {
+	update := func(rule string, recipient Recipient, add bool) (string, bool) {
+		var root yaml.Node
+		assert.NoError(t, yaml.Unmarshal([]byte(rule), &root))
+		changed, err := updateRule(root.Content[0], recipient, add)
+		assert.NoError(t, err)
+		data, err := yaml.Marshal(&root)
+		assert.NoError(t, err)
+		return string(data), changed
+	}
+	alice := Recipient{Type: "age", Key: "age1alice"}
+
+	rule, changed := update("path_regex: .*\nage: age1bob, age1carol # team\n", alice, true)
+	assert.True(t, changed)
+	assert.Equal(t, "path_regex: .*\nage: age1bob,age1carol,age1alice # team\n", rule)
+	_, changed = update("age: age1bob,age1alice\n", alice, true)
+	assert.False(t, changed)
+
+	rule, changed = update("age:\n    - age1alice\n    - age1bob\n", alice, false)
+	assert.True(t, changed)
+	assert.Equal(t, "age:\n    - age1bob\n", rule)
+
+	rule, changed = update("pgp: 85D77543B3D624B6\n", alice, true)
+	assert.True(t, changed)
+	assert.Equal(t, "pgp: 85D77543B3D624B6\nage: age1alice\n", rule)
+	_, changed = update("pgp: 85D77543B3D624B6\n", alice, false)
+	assert.False(t, changed)
+
+	rule, changed = update("key_groups:\n    - age:\n        - age1alice\n    - age:\n        - age1alice\n", alice, false)
+	assert.True(t, changed)
+	assert.Equal(t, "key_groups:\n    - age: []\n    - age: []\n", rule)
+
+	var root yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte("key_groups:\n  - kms:\n      - arn: arn:aws:kms:key\n"), &root))
+	_, err := updateRule(root.Content[0], Recipient{Type: "kms", Key: "arn:aws:kms:other"}, true)
+	assert.Error(t, err)
+}
@@ -0,0 +1,99 @@
+package sops
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+)
+
+// Decrypter decrypts SOPS-encrypted files
+type Decrypter interface {
+	// Decrypt returns the plaintext content of an encrypted file, in the same format as "sops -d" would
+	Decrypt(filename string) ([]byte, error)
+}
+
+// Encrypter encrypts files with SOPS
+type Encrypter interface {
+	// Encrypt returns the encrypted form of plaintext, the filename determines the file format and which .sops.yaml creation rule applies
+	Encrypt(filename string, plaintext []byte) ([]byte, error)
+	// Update sets or removes values at key paths within an encrypted file in-place, all in a single pass
+	Update(filename string, changes ...Change) error
+}
+
+// Change is a value to be set at a key path, creating missing maps on the way.
+// With Delete the key is removed instead, together with everything below it.
+// Maps within the value are written in the key order known by Order, or sorted without one
+type Change struct {
+	Path   []string
+	Value  any
+	Order  *ordered.Order
+	Delete bool
+}
+
+// Backend can both decrypt and encrypt SOPS files
+type Backend interface {
+	Decrypter
+	Encrypter
+}
+
+// backend is used by all package level functions, the sops CLI unless configured otherwise
+var backend Backend = CLI{}
+
+// New returns the backend of the given name, either "cli" or "age"
+func New(name string) (Backend, error) {
+	switch name {
+	case "", "cli":
+		return CLI{}, nil
+	case "age":
+		return &Age{}, nil
+	}
+	return nil, fmt.Errorf("unknown SOPS backend [%s], must be one of cli or age", name)
+}
+
+// SetBackend replaces the backend used by the package level functions
+func SetBackend(b Backend) {
+	backend = b
+}
+
+// GetBackend returns the backend currently used by the package level functions
+func GetBackend() Backend {
+	return backend
+}
+
+func Decrypt(filename string) ([]byte, error) {
+	return backend.Decrypt(filename)
+}
+
+func Encrypt(filename string, plaintext []byte) ([]byte, error) {
+	return backend.Encrypt(filename, plaintext)
+}
+
+func Update(filename string, changes ...Change) error {
+	return backend.Update(filename, changes...)
+}
+
+// Set sets a single value at a key path within an encrypted file in-place, like "sops --set" does
+func Set(filename string, path []string, value any) error {
+	return backend.Update(filename, Change{Path: path, Value: value})
+}
+
+// Unset removes a key and everything below it from an encrypted file in-place, like "sops unset" does
+func Unset(filename string, path []string) error {
+	return backend.Update(filename, Change{Path: path, Delete: true})
+}
+
+// Format returns the SOPS file format of a file, which like for sops itself is determined by its extension
+func Format(filename string) string {
+	switch filepath.Ext(filename) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".json":
+		return "json"
+	case ".env":
+		return "dotenv"
+	case ".ini":
+		return "ini"
+	}
+	return "binary"
+}
@@ -0,0 +1,263 @@
+package sops
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	_ = os.Chdir("../../_fixtures") // change to fixtures dir
+	log.Initialize()
+	os.Setenv("SOPS_AGE_KEY_FILE", "age.key")
+}
+
+const plaintextYAML = `# database settings
+database:
+  host: db.example.com
+  port: 5432
+  ratio: 0.75
+  enabled: true
+  password: "s3cr3t"
+  empty: ""
+  nothing: null
+  hosts:
+    - one
+    - two
+  host_unencrypted: visible
+certificate: |
+  -----BEGIN CERTIFICATE-----
+  MIIB
+  -----END CERTIFICATE-----
+`
+
+func Test_Age_Decrypt(t *testing.T) {
+	for _, filename := range []string{"secrets.yaml", "input/infrastructure/terraform/terraform.tfstate.backup.sops_enc"} {
+		expected, err := CLI{}.Decrypt(filename)
+		assert.NoError(t, err)
+
+		decrypted, err := (&Age{}).Decrypt(filename)
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), string(decrypted))
+	}
+}
+
+func Test_Age_Decrypt_tampered(t *testing.T) {
+	data, err := os.ReadFile("secrets.yaml")
+	assert.NoError(t, err)
+	filename := filepath.Join(t.TempDir(), "secrets.yaml")
+
+	// swapping two encrypted values of the same key breaks their authenticated data
+	lines := strings.Split(string(data), "\n")
+	lines[1], lines[2] = strings.Replace(lines[2], "secret_key", "access_key", 1), strings.Replace(lines[1], "access_key", "secret_key", 1)
+	assert.NoError(t, os.WriteFile(filename, []byte(strings.Join(lines, "\n")), 0600))
+	_, err = (&Age{}).Decrypt(filename)
+	assert.ErrorContains(t, err, "could not decrypt with AES-GCM")
+
+	_, err = (&Age{}).Decrypt("plato.yaml")
+	assert.ErrorContains(t, err, "contains no SOPS metadata")
+}
+
+func Test_Age_Encrypt(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "values.yaml")
+	encrypted, err := (&Age{}).Encrypt(filename, []byte(plaintextYAML))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filename, encrypted, 0600))
+
+	assert.Contains(t, string(encrypted), "  port: ENC[AES256_GCM,data:")
+	assert.Contains(t, string(encrypted), "type:int]")
+	assert.Contains(t, string(encrypted), "  host_unencrypted: visible\n")
+	assert.Contains(t, string(encrypted), "  empty: \"\"\n")
+	assert.Contains(t, string(encrypted), "#ENC[AES256_GCM,data:")
+	assert.Contains(t, string(encrypted), "recipient: age1yapc0k0tfz8cketuldrjq3vyuzne4587zmf3d2ejypaftg95yvrs8r44yh")
+	assert.NotContains(t, string(encrypted), "s3cr3t")
+
+	// the sops binary must be able to decrypt it too
+	expected, err := CLI{}.Decrypt(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(expected), "# database settings\n")
+	assert.Contains(t, string(expected), "  password: s3cr3t\n")
+
+	decrypted, err := (&Age{}).Decrypt(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), string(decrypted))
+
+	// binary files
+	filename = filepath.Join(t.TempDir(), "example.json.sops_enc")
+	encrypted, err = (&Age{}).Encrypt(filename, []byte("{\"plain\": \"text\"}\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filename, encrypted, 0600))
+	assert.Contains(t, string(encrypted), "\t\"data\": \"ENC[AES256_GCM,data:")
+
+	expected, err = CLI{}.Decrypt(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"plain\": \"text\"}\n", string(expected))
+}
+
+func Test_Age_Update(t *testing.T) {
+	data, err := os.ReadFile("secrets.yaml")
+	assert.NoError(t, err)
+	filename := filepath.Join(t.TempDir(), "secrets.yaml")
+	assert.NoError(t, os.WriteFile(filename, data, 0600))
+
+	assert.NoError(t, (&Age{}).Update(filename,
+		Change{Path: []string{"registry", "password"}, Value: "changed"},
+		Change{Path: []string{"new", "nested", "value"}, Value: "multi\nline\n"},
+		Change{Path: []string{"kubernetes"}, Delete: true},
+		Change{Path: []string{"does", "not", "exist"}, Delete: true},
+	))
+	assert.NoError(t, (&Age{}).Update(filename))
+
+	updated, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	// unchanged values keep their ciphertext, to avoid unnecessary git spam
+	for _, line := range strings.Split(string(data), "\n")[:4] {
+		assert.Contains(t, string(updated), line)
+	}
+	assert.NotContains(t, string(updated), "password: ENC[AES256_GCM,data:3C/nwQBGppSlEoD/HLaWAYMjVcWkAbsBRlpl")
+
+	decrypted, err := CLI{}.Decrypt(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(decrypted), "  password: changed\n")
+	assert.Contains(t, string(decrypted), "new:\n  nested:\n    value: |\n      multi\n      line\n")
+	assert.NotContains(t, string(decrypted), "kubernetes:")
+}
+
+// interopYAML is the plaintext of sops/interop.yaml, which has been encrypted by the sops binary with an encrypted_regex
+const interopYAML = `# database settings
+database:
+  host: db.example.com
+  port: 5432
+  ratio: 0.75
+  enabled: true
+  password: s3cr3t
+  hosts:
+    - one
+    - two
+certificate: |
+  -----BEGIN CERTIFICATE-----
+  MIIB
+  -----END CERTIFICATE-----
+`
+
+func Test_Age_interop(t *testing.T) {
+	// files encrypted by the sops binary
+	decrypted, err := (&Age{}).Decrypt("sops/interop.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, interopYAML, string(decrypted))
+
+	// files updated by the age backend are still readable by the sops binary
+	data, err := os.ReadFile("sops/interop.yaml")
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "interop.yaml")
+	assert.NoError(t, os.WriteFile(filename, data, 0640))
+	assert.NoError(t, (&Age{}).Update(filename,
+		Change{Path: []string{"database", "password"}, Value: "changed"},
+		Change{Path: []string{"database", "user"}, Value: "admin"},
+	))
+
+	updated, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(updated), "  user: admin\n") // not matched by the encrypted_regex
+	assert.NotContains(t, string(updated), "changed")
+	expected := strings.Replace(interopYAML, "  password: s3cr3t\n", "  password: changed\n", 1)
+	expected = strings.Replace(expected, "    - two\n", "    - two\n  user: admin\n", 1)
+	decrypted, err = CLI{}.Decrypt(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(decrypted))
+
+	// the file is replaced as a whole, keeping its permissions
+	info, err := os.Stat(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func Test_CLI(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "values.yaml")
+	encrypted, err := CLI{}.Encrypt(filename, []byte(plaintextYAML))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filename, encrypted, 0600))
+
+	assert.NoError(t, CLI{}.Update(filename, Change{Path: []string{"database", "password"}, Value: `"quoted" & <escaped>`}))
+	decrypted, err := (&Age{}).Decrypt(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(decrypted), `  password: '"quoted" & <escaped>'`)
+
+	// several changes are encrypted in one go, keeping the data key
+	before, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.NoError(t, CLI{}.Update(filename,
+		Change{Path: []string{"database", "user"}, Value: "admin"},
+		Change{Path: []string{"new", "value"}, Value: "multi\nline\n"},
+	))
+	after, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(after), strings.Split(strings.Split(string(before), "enc: |\n")[1], "\n")[1])
+	decrypted, err = (&Age{}).Decrypt(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(decrypted), "  user: admin\n")
+	assert.Contains(t, string(decrypted), "new:\n  value: |\n    multi\n    line\n")
+
+	// keys are removed with "sops unset", or together with other changes
+	assert.NoError(t, CLI{}.Update(filename, Change{Path: []string{"database", "ratio"}, Delete: true}))
+	assert.NoError(t, CLI{}.Update(filename, Change{Path: []string{"database", "port"}, Delete: true}, Change{Path: []string{"nothing", "here"}, Delete: true}))
+	decrypted, err = (&Age{}).Decrypt(filename)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(decrypted), "ratio:")
+	assert.NotContains(t, string(decrypted), "port:")
+	after, err = os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	// an unchanged file is left alone
+	assert.NoError(t, CLI{}.Update(filename, Change{Path: []string{"database", "user"}, Value: "admin"}, Change{Path: []string{"new", "value"}, Value: "multi\nline\n"}))
+	unchanged, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, string(after), string(unchanged))
+
+	_, err = CLI{}.Decrypt("does-not-exist.yaml")
+	assert.ErrorContains(t, err, "exit status")
+}
+
+func Test_Fake(t *testing.T) {
+	defer SetBackend(GetBackend())
+	fake := NewFake()
+	SetBackend(fake)
+
+	encrypted, err := Encrypt("secrets.yaml", []byte("registry:\n  username: admin\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "FAKE[secrets.yaml]", string(encrypted))
+
+	assert.NoError(t, Set("secrets.yaml", []string{"registry", "password"}, "secret"))
+	decrypted, err := Decrypt("secrets.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "registry:\n    password: secret\n    username: admin\n", string(decrypted))
+
+	assert.NoError(t, Unset("secrets.yaml", []string{"registry", "username"}))
+	decrypted, err = Decrypt("secrets.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "registry:\n    password: secret\n", string(decrypted))
+
+	_, err = Decrypt("other.yaml")
+	assert.Error(t, err)
+}
+
+func Test_New(t *testing.T) {
+	backend, err := New("")
+	assert.NoError(t, err)
+	assert.Equal(t, CLI{}, backend)
+
+	backend, err = New("age")
+	assert.NoError(t, err)
+	assert.IsType(t, &Age{}, backend)
+
+	_, err = New("vault")
+	assert.Error(t, err)
+}
@@ -0,0 +1,259 @@
+package sops
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"gopkg.in/yaml.v3"
+)
+
+// leafFunc is called for every scalar value of a tree, path being the map keys leading to it
+type leafFunc func(node *yaml.Node, path []string) error
+
+// commentFunc is called for every comment line of a tree, path being the map keys leading to the map or list the comment belongs to
+type commentFunc func(comment *string, path []string) error
+
+// walk goes through all values and comments of a tree in document order, which is the order SOPS computes its MAC in.
+// Like in SOPS only map keys are part of a path, list items share the path of their list
+func walk(node *yaml.Node, path []string, onLeaf leafFunc, onComment commentFunc) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if err := comments(node, path, onComment); err != nil {
+			return err
+		}
+		for _, child := range node.Content {
+			if err := walk(child, path, onLeaf, onComment); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if err := comments(node, path, onComment); err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if err := comments(key, path, onComment); err != nil {
+				return err
+			}
+			if value.Kind == yaml.ScalarNode {
+				if err := comments(value, path, onComment); err != nil {
+					return err
+				}
+			}
+			if err := walk(value, append(slices.Clone(path), key.Value), onLeaf, onComment); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		if err := comments(node, path, onComment); err != nil {
+			return err
+		}
+		for _, item := range node.Content {
+			if item.Kind == yaml.ScalarNode {
+				if err := comments(item, path, onComment); err != nil {
+					return err
+				}
+			}
+			if err := walk(item, path, onLeaf, onComment); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		return onLeaf(node, path)
+	case yaml.AliasNode:
+		return fmt.Errorf("YAML aliases are not supported, at [%s]", strings.Join(path, "."))
+	}
+	return nil
+}
+
+// comments calls onComment for every single line of the head, line and foot comments of a node
+func comments(node *yaml.Node, path []string, onComment commentFunc) error {
+	if onComment == nil {
+		return nil
+	}
+	for _, comment := range []*string{&node.HeadComment, &node.LineComment, &node.FootComment} {
+		if len(*comment) == 0 {
+			continue
+		}
+		lines := strings.Split(*comment, "\n")
+		for i := range lines {
+			if len(lines[i]) == 0 {
+				continue
+			}
+			if err := onComment(&lines[i], path); err != nil {
+				return err
+			}
+		}
+		*comment = strings.Join(lines, "\n")
+	}
+	return nil
+}
+
+// scalarValue returns the Go value of a scalar node, with the types SOPS knows about
+func scalarValue(node *yaml.Node) (any, error) {
+	var value any
+	if err := node.Decode(&value); err != nil {
+		return nil, err
+	}
+	switch value.(type) {
+	case nil, string, int, float64, bool, time.Time:
+		return value, nil
+	}
+	return nil, fmt.Errorf("unsupported value [%s] of type %T", node.Value, value)
+}
+
+// setValue replaces the value of a node, keeping its comments
+func setValue(node *yaml.Node, value any, order *ordered.Order) error {
+	replacement, err := ordered.ToNode(value, order)
+	if err != nil {
+		return err
+	}
+	replacement.HeadComment, replacement.LineComment, replacement.FootComment = node.HeadComment, node.LineComment, node.FootComment
+	*node = *replacement
+	return nil
+}
+
+// setString replaces the value of a node with a plain string, keeping its comments
+func setString(node *yaml.Node, value string) {
+	node.Kind = yaml.ScalarNode
+	node.Tag = "!!str"
+	node.Value = value
+	node.Style = 0
+	node.Content = nil
+}
+
+// mapValue returns the value node of a key within a map node, or nil
+func mapValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// removeKey removes a key from a map node and returns its value node, or nil
+func removeKey(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			value := node.Content[i+1]
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return value
+		}
+	}
+	return nil
+}
+
+// applyChange sets or removes the value of a change within a document's root node
+func applyChange(root *yaml.Node, change Change) error {
+	if len(change.Path) == 0 {
+		return fmt.Errorf("no key path given")
+	}
+	if change.Delete {
+		return deletePath(root, change.Path)
+	}
+	return setPath(root, change.Path, change.Value, change.Order)
+}
+
+// deletePath removes the value at a key path, a path that doesn't exist is left alone
+func deletePath(node *yaml.Node, path []string) error {
+	for i, key := range path[:len(path)-1] {
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("[%s] is not a map", strings.Join(path[:i], "."))
+		}
+		if node = mapValue(node, key); node == nil {
+			return nil
+		}
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("[%s] is not a map", strings.Join(path[:len(path)-1], "."))
+	}
+	removeKey(node, path[len(path)-1])
+	return nil
+}
+
+// setPath sets value at a key path within a map node, creating missing maps on the way
+func setPath(node *yaml.Node, path []string, value any, order *ordered.Order) error {
+	for i, key := range path {
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("[%s] is not a map", strings.Join(path[:i], "."))
+		}
+		child := mapValue(node, key)
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+		}
+		node = child
+	}
+	return setValue(node, value, order)
+}
+
+// toJSON encodes a tree as JSON, keeping the order of all keys
+func toJSON(node *yaml.Node, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, node); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", indent); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeJSON(buf, node.Content[0])
+	case yaml.MappingNode:
+		buf.WriteString("{")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			key, err := marshalJSON(node.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteString(":")
+			if err := writeJSON(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("}")
+	case yaml.SequenceNode:
+		buf.WriteString("[")
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			if err := writeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]")
+	case yaml.ScalarNode:
+		value, err := scalarValue(node)
+		if err != nil {
+			return err
+		}
+		data, err := marshalJSON(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	case yaml.AliasNode:
+		return writeJSON(buf, node.Alias)
+	}
+	return nil
+}
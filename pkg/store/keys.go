@@ -0,0 +1,202 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/spf13/viper"
+)
+
+// FileRecipients are the recipients of an encrypted file, and how they differ from what they should be:
+// the creation rule in .sops.yaml matching the file, or the recipients of secrets.yaml if there is none
+type FileRecipients struct {
+	File       string
+	Recipients []sops.Recipient
+	Missing    []sops.Recipient
+	Extra      []sops.Recipient
+	Rule       bool // recipients are compared to a creation rule
+}
+
+// Diverges checks if the recipients differ from what they should be
+func (f FileRecipients) Diverges() bool {
+	return len(f.Missing) > 0 || len(f.Extra) > 0
+}
+
+// EncryptedFiles returns all files whose recipients "plato keys" manages: secrets.yaml,
+// plato.yaml if it is SOPS-encrypted and every *.sops_enc file in 'plato.source'
+func EncryptedFiles() ([]string, error) {
+	files := make([]string, 0)
+	for _, f := range []string{config.SecretsFile(), viper.ConfigFileUsed()} {
+		if len(f) > 0 && sops.IsEncryptedFile(f) && !slices.Contains(files, relativePath(f)) {
+			files = append(files, relativePath(f))
+		}
+	}
+	err := filepath.Walk(config.DirSource(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && filepath.Ext(path) == ".sops_enc" {
+			files = append(files, relativePath(path))
+		}
+		return nil
+	})
+	return files, err
+}
+
+// relativePath returns paths relative to the working directory, which is where .sops.yaml creation rules apply to
+func relativePath(path string) string {
+	pwd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if relative, err := filepath.Rel(pwd, path); err == nil {
+		return relative
+	}
+	return path
+}
+
+// ListRecipients returns the recipients of all encrypted files
+func ListRecipients() ([]FileRecipients, error) {
+	files, err := EncryptedFiles()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]FileRecipients, 0, len(files))
+	var reference []sops.Recipient
+	for i, f := range files {
+		recipients, err := sops.Recipients(f)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			reference = recipients // secrets.yaml, or whichever file comes first
+		}
+
+		expected, rule, err := sops.RuleRecipients(f)
+		if err != nil {
+			return nil, err
+		}
+		if !rule {
+			expected = reference
+		}
+		missing, extra := sops.DiffRecipients(expected, recipients)
+		result = append(result, FileRecipients{File: f, Recipients: recipients, Missing: missing, Extra: extra, Rule: rule})
+	}
+	return result, nil
+}
+
+// AddRecipient adds a recipient to all encrypted files, and to all creation rules in .sops.yaml they use.
+// Files with a creation rule are updated to exactly its recipients, all others get the recipient added
+func AddRecipient(recipient sops.Recipient) error {
+	return updateRecipients(recipient, true)
+}
+
+// RemoveRecipient removes a recipient from all encrypted files, and from all creation rules in .sops.yaml they use.
+// Every file it has been removed from gets a new data key, the old one might have been kept
+func RemoveRecipient(recipient sops.Recipient) error {
+	return updateRecipients(recipient, false)
+}
+
+func updateRecipients(recipient sops.Recipient, add bool) error {
+	files, err := EncryptedFiles()
+	if err != nil {
+		return err
+	}
+	changed, err := sops.UpdateCreationRules(files, recipient, add)
+	if err != nil {
+		return err
+	}
+	if changed {
+		log.Infof("updated creation rules in [%s]", color.Magenta(".sops.yaml"))
+	}
+
+	err = forEachFile(files, func(f string) error {
+		recipients, err := sops.Recipients(f)
+		if err != nil {
+			return err
+		}
+		missing, _ := sops.DiffRecipients([]sops.Recipient{recipient}, recipients)
+		present := len(missing) == 0
+		_, rule, err := sops.RuleRecipients(f)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case rule:
+			if err := sops.UpdateKeys(f); err != nil {
+				return err
+			}
+			if !add && present {
+				// the recipient had access to this file, it might have kept its data key
+				if err := sops.RotateDataKey(f); err != nil {
+					return err
+				}
+			}
+		case add && !present:
+			if err := sops.AddRecipient(f, recipient); err != nil {
+				return err
+			}
+		case !add && present:
+			if err := sops.RemoveRecipient(f, recipient); err != nil {
+				return err
+			}
+		default:
+			log.Debugf("[%s] doesn't need to be updated", color.Magenta(f))
+			return nil
+		}
+		log.Infof("updated recipients of [%s]", color.Magenta(f))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// files with a creation rule that doesn't match the others, or with unrelated recipients, still diverge
+	result, err := ListRecipients()
+	if err != nil {
+		return err
+	}
+	for _, f := range result {
+		if f.Diverges() {
+			log.Warnf("recipients of [%s] still diverge", color.Magenta(f.File))
+		}
+	}
+	return nil
+}
+
+// RotateDataKeys generates new data keys for all encrypted files and re-encrypts them
+func RotateDataKeys() error {
+	files, err := EncryptedFiles()
+	if err != nil {
+		return err
+	}
+	return forEachFile(files, func(f string) error {
+		if err := sops.RotateDataKey(f); err != nil {
+			return err
+		}
+		log.Infof("rotated data key of [%s]", color.Magenta(f))
+		return nil
+	})
+}
+
+// forEachFile calls fn for every file, failures are reported without stopping, so that no file is silently skipped
+func forEachFile(files []string, fn func(f string) error) error {
+	failed := 0
+	for _, f := range files {
+		if err := fn(f); err != nil {
+			log.Errorf("could not update [%s]: %s", color.Magenta(f), color.Red("%v", err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) could not be updated", failed, len(files))
+	}
+	return nil
+}
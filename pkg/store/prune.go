@@ -0,0 +1,92 @@
+package store
+
+import (
+	"slices"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+)
+
+// staleSecrets returns the keys below 'plato.secrets_namespace' in secrets.yaml that none of the generated secrets
+// are stored under anymore. Whole subtrees without any generated secret are returned as a single key
+func staleSecrets(paths [][]string) [][]string {
+	namespace := config.SecretsNamespace()
+	if len(namespace) == 0 {
+		return nil
+	}
+
+	// the template payload might not contain all secrets, so read them all from secrets.yaml
+	decrypted, err := sops.Decrypt(config.SecretsFile())
+	if err != nil {
+		log.Fatalf("could not decrypt [%s] with SOPS: %s", color.Magenta(config.SecretsFile()), color.Red("%v", err))
+	}
+	order := ordered.NewOrder()
+	object, err := ordered.Unmarshal(decrypted, order)
+	if err != nil {
+		log.Fatalf("could not parse [%s]: %s", color.Magenta(config.SecretsFile()), color.Red("%v", err))
+	}
+
+	namespacePath := config.SplitPath(namespace)
+	for _, key := range namespacePath {
+		m, ok := object.(map[string]any)
+		if !ok {
+			return nil
+		}
+		object = m[key]
+	}
+	m, ok := object.(map[string]any)
+	if !ok {
+		return nil
+	}
+	return collectStale(m, namespacePath, paths, order)
+}
+
+func collectStale(m map[string]any, parent []string, paths [][]string, order *ordered.Order) [][]string {
+	stale := make([][]string, 0)
+	for _, key := range order.Keys(m) {
+		path := append(slices.Clone(parent), key)
+		switch {
+		case covered(path, paths):
+			continue // a generated secret is stored at or above this key
+		case !containsAny(path, paths):
+			stale = append(stale, path) // nothing generated below this key at all
+		default:
+			if child, ok := m[key].(map[string]any); ok {
+				stale = append(stale, collectStale(child, path, paths, order)...)
+			}
+		}
+	}
+	return stale
+}
+
+// covered checks if any of the paths equals or is a parent of path
+func covered(path []string, paths [][]string) bool {
+	for _, p := range paths {
+		if len(p) <= len(path) && slices.Equal(p, path[:len(p)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny checks if any of the paths is below path
+func containsAny(path []string, paths [][]string) bool {
+	for _, p := range paths {
+		if len(p) > len(path) && slices.Equal(p[:len(path)], path) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneSecrets returns the changes to remove all stale keys
+func pruneSecrets(stale [][]string) []sops.Change {
+	changes := make([]sops.Change, 0, len(stale))
+	for _, path := range stale {
+		changes = append(changes, sops.Change{Path: path, Delete: true})
+	}
+	return changes
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"gopkg.in/yaml.v3"
+)
+
+// rotationFilename is written into 'plato.target' by "plato rotate". It keeps the rotated values in plaintext,
+// same as the rendered files, until store-secrets stores them into secrets.yaml
+const rotationFilename = ".plato-rotation.yaml"
+
+// rotationMetadata is the value path in secrets.yaml below which the time of the last rotation of each secret is kept
+var rotationMetadata = []string{"plato_metadata", "rotated"}
+
+// rotation is a rotated secret that has not been stored yet
+type rotation struct {
+	Key     string `yaml:"key"`
+	Value   any    `yaml:"value"`
+	Rotated string `yaml:"rotated"`
+}
+
+func rotationPath() string {
+	return filepath.Join(config.DirTarget(), rotationFilename)
+}
+
+// SaveRotations remembers rotated values to be stored by store-secrets. "plato rotate" re-renders all templates,
+// which refuses to run while the git repository is tainted, so there are never earlier rotations to keep
+func SaveRotations(values map[string]any, rotated time.Time) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	rotations := make([]rotation, 0, len(keys))
+	for _, key := range keys {
+		rotations = append(rotations, rotation{Key: key, Value: values[key], Rotated: rotated.UTC().Format(time.RFC3339)})
+	}
+
+	data, err := yaml.Marshal(rotations)
+	if err != nil {
+		log.Fatalf("could not write rotated secrets: %v", err)
+	}
+	if err := os.WriteFile(rotationPath(), data, 0600); err != nil {
+		log.Fatalf("could not write rotated secrets into [%s]: %v", color.Magenta(rotationPath()), err)
+	}
+}
+
+// loadRotations reads all rotated secrets that have not been stored yet
+func loadRotations() []rotation {
+	data, err := os.ReadFile(rotationPath())
+	if err != nil {
+		return nil
+	}
+	var rotations []rotation
+	if err := yaml.Unmarshal(data, &rotations); err != nil {
+		log.Fatalf("could not read rotated secrets from [%s]: %v", color.Magenta(rotationPath()), err)
+	}
+	return rotations
+}
+
+// withRotations adds all rotated secrets and their rotation timestamps to the changes to store.
+// A rotated value replaces whatever has been generated at or below its key
+func withRotations(changes []sops.Change) []sops.Change {
+	rotations := loadRotations()
+	if len(rotations) == 0 {
+		return changes
+	}
+	paths := make([][]string, 0, len(rotations))
+	for _, r := range rotations {
+		paths = append(paths, config.SplitPath(r.Key))
+	}
+	changes = slices.DeleteFunc(changes, func(change sops.Change) bool {
+		return !change.Delete && covered(change.Path, paths)
+	})
+	for i, r := range rotations {
+		changes = append(changes,
+			sops.Change{Path: paths[i], Value: r.Value},
+			sops.Change{Path: append(slices.Clone(rotationMetadata), r.Key), Value: r.Rotated},
+		)
+	}
+	return changes
+}
+
+// removeRotations deletes the rotated secrets once they have been stored
+func removeRotations() {
+	_ = os.Remove(rotationPath())
+}
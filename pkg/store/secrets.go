@@ -0,0 +1,204 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/command"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+)
+
+// decryptTree decrypts a SOPS-encrypted YAML file into maps, slices and scalars, remembering their key order in the given order
+func decryptTree(filename string, order *ordered.Order) (map[string]any, error) {
+	decrypted, err := sops.Decrypt(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseTree(filename, decrypted, order)
+}
+
+func parseTree(filename string, data []byte, order *ordered.Order) (map[string]any, error) {
+	object, err := ordered.Unmarshal(data, order)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse [%s]: %v", filename, err)
+	}
+	if object == nil {
+		return make(map[string]any), nil
+	}
+	m, ok := object.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("could not parse [%s]: top-level value must be a map", filename)
+	}
+	return m, nil
+}
+
+// ListSecrets returns the value paths of all secrets within an encrypted file, lists count as a single secret
+func ListSecrets(filename string) ([]string, error) {
+	order := ordered.NewOrder()
+	tree, err := decryptTree(filename, order)
+	if err != nil {
+		return nil, err
+	}
+	return leafPaths(tree, nil, order), nil
+}
+
+func leafPaths(m map[string]any, parent []string, order *ordered.Order) []string {
+	paths := make([]string, 0)
+	for _, key := range order.Keys(m) {
+		path := append(append([]string{}, parent...), key)
+		if child, ok := m[key].(map[string]any); ok && len(child) > 0 {
+			paths = append(paths, leafPaths(child, path, order)...)
+			continue
+		}
+		paths = append(paths, config.JoinPath(path))
+	}
+	return paths
+}
+
+// GetSecret returns the decrypted value found under the given path, binary values are decoded.
+// The key order of its maps is remembered in the given order
+func GetSecret(filename, key string, order *ordered.Order) (any, error) {
+	tree, err := decryptTree(filename, order)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := config.LookupIn(tree, key)
+	if !ok {
+		return nil, fmt.Errorf("[%s] not found in [%s]", key, filename)
+	}
+	return config.DecodeBinaryValue(value), nil
+}
+
+// SetSecret stores data under the given path, encoded the same way store-secrets does.
+// The source filename determines if .yaml and .json content is stored as a subtree with 'plato.secrets_structured'
+func SetSecret(filename, key, source string, data []byte) error {
+	tree, err := decryptTree(filename, nil)
+	if err != nil {
+		return err
+	}
+	order := ordered.NewOrder()
+	// resolve the path the same way GetSecret does, so an existing literal key like "a.b" is replaced instead of creating a.b nested
+	return sops.Update(filename, sops.Change{Path: config.KeyPathIn(tree, key), Value: secretValue(source, data, order), Order: order})
+}
+
+// RemoveSecret removes the given path and everything below it
+func RemoveSecret(filename, key string) error {
+	tree, err := decryptTree(filename, nil)
+	if err != nil {
+		return err
+	}
+	if _, ok := config.LookupIn(tree, key); !ok {
+		return fmt.Errorf("[%s] not found in [%s]", key, filename)
+	}
+	return sops.Update(filename, sops.Change{Path: config.KeyPathIn(tree, key), Delete: true})
+}
+
+// EditSecrets opens the decrypted file in $EDITOR, the plaintext is only ever written to a private temporary directory,
+// on tmpfs if available, both for editing and when sops.Update stores it back. Only top-level keys that have been changed are stored back.
+// If the edited file doesn't parse the editor is opened again with the error on top, saving it again unchanged keeps the file and returns its path
+func EditSecrets(filename string) error {
+	decrypted, err := sops.Decrypt(filename)
+	if err != nil {
+		return err
+	}
+	beforeOrder := ordered.NewOrder()
+	before, err := parseTree(filename, decrypted, beforeOrder)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := sops.PrivateTempDir()
+	if err != nil {
+		return err
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			os.RemoveAll(tmpDir)
+		}
+	}()
+	tmpFile := filepath.Join(tmpDir, filepath.Base(filename))
+	if err := os.WriteFile(tmpFile, decrypted, 0600); err != nil {
+		return err
+	}
+
+	editor := os.Getenv("EDITOR")
+	if len(editor) == 0 {
+		editor = "vi"
+	}
+	var after map[string]any
+	afterOrder := ordered.NewOrder()
+	written := decrypted
+	for {
+		// run through the shell, $EDITOR might contain arguments like "code --wait"
+		if err := command.ExecInteractive([]string{"sh", "-c", editor + ` "$1"`, "--", tmpFile}); err != nil {
+			return fmt.Errorf("editor failed: %v", err)
+		}
+		edited, err := os.ReadFile(tmpFile)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(stripEditError(edited), decrypted) {
+			log.Infof("no changes")
+			return nil
+		}
+		after, err = parseTree(filename, edited, afterOrder)
+		if err == nil {
+			break
+		}
+		if bytes.Equal(stripEditError(edited), stripEditError(written)) {
+			// saved again without fixing it, keep the edits around instead of throwing them away
+			keep = true
+			return fmt.Errorf("%v, your edits have been kept in [%s], remove it when you're done", err, tmpFile)
+		}
+		// re-open the editor with the error on top of the edited content
+		log.Errorf("%v", err)
+		written = append([]byte(editError(err)), stripEditError(edited)...)
+		if err := os.WriteFile(tmpFile, written, 0600); err != nil {
+			return err
+		}
+	}
+
+	changes := make([]sops.Change, 0)
+	for _, key := range afterOrder.Keys(after) {
+		if current, ok := before[key]; !ok || !reflect.DeepEqual(current, after[key]) {
+			changes = append(changes, sops.Change{Path: []string{key}, Value: after[key], Order: afterOrder})
+		}
+	}
+	for _, key := range beforeOrder.Keys(before) {
+		if _, ok := after[key]; !ok {
+			changes = append(changes, sops.Change{Path: []string{key}, Delete: true})
+		}
+	}
+	return sops.Update(filename, changes...)
+}
+
+// editErrorPrefix marks the comment lines EditSecrets puts on top of a file that didn't parse
+const editErrorPrefix = "# plato: "
+
+func editError(err error) string {
+	var b strings.Builder
+	for _, line := range strings.Split(err.Error(), "\n") {
+		b.WriteString(editErrorPrefix + line + "\n")
+	}
+	b.WriteString(editErrorPrefix + "fix the error and save again, save without changes to abort\n")
+	return b.String()
+}
+
+func stripEditError(data []byte) []byte {
+	for bytes.HasPrefix(data, []byte(editErrorPrefix)) {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return nil
+		}
+		data = data[i+1:]
+	}
+	return data
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/file"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"gopkg.in/yaml.v3"
+)
+
+// stateDir is written by "plato render", next to the taint marker instead of into 'plato.target'. It contains hashes of
+// the secrets as they were at render time, the common base for store-secrets to detect if both a rendered copy and its
+// source changed since. The hashes would allow guessing weak secrets, so the directory ignores itself and is never committed
+const (
+	stateDir      = ".plato"
+	stateFilename = "state.yaml"
+)
+
+// legacyStateFilename is where older versions of plato wrote the render state into 'plato.target'
+const legacyStateFilename = ".plato-state.yaml"
+
+// renderState contains the fingerprints of all generated secret values in secrets.yaml, keyed by their value path,
+// and of all .sops_enc plaintexts, keyed by their source file
+type renderState struct {
+	Secrets map[string]string `yaml:"secrets"`
+	Files   map[string]string `yaml:"files"`
+}
+
+func statePath() string {
+	return filepath.Join(stateDir, stateFilename)
+}
+
+// RecordRenderState remembers the current value of every generated secret and the plaintext of every .sops_enc file
+func RecordRenderState() {
+	state := renderState{Secrets: make(map[string]string), Files: make(map[string]string)}
+
+	walkGeneratedSecrets(func(_ string, _ os.FileInfo, valuePath []string) {
+		if !config.Loaded(valuePath[0]) {
+			return // not loaded for rendering, the value in secrets.yaml is unknown
+		}
+		key := config.JoinPath(valuePath)
+		value, _ := config.Lookup(key)
+		state.Secrets[key] = fingerprint(value)
+	})
+
+	err := walkEncryptedFiles(func(source, rendered string) error {
+		data, err := os.ReadFile(rendered)
+		if err != nil {
+			return err
+		}
+		state.Files[source] = fingerprint(data)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("could not record render state: %v", err)
+	}
+
+	writeRenderState(state)
+	log.Debugf("recorded render state in [%s]", color.Magenta(statePath()))
+
+	legacy := filepath.Join(config.DirTarget(), legacyStateFilename)
+	if file.Exists(legacy) {
+		log.Warnf("removing [%s], the render state is kept in [%s] now, which is not committed", color.Magenta(legacy), color.Magenta(statePath()))
+		_ = file.Delete(legacy)
+	}
+}
+
+// updateRenderState records everything just stored as the new base, it matches the rendered copies again
+func updateRenderState(files []encryptedFile, changes []sops.Change) {
+	state := loadRenderState()
+	if state == nil {
+		return
+	}
+	for _, f := range files {
+		state.Files[f.source] = fingerprint(f.content)
+	}
+	for _, change := range changes {
+		if change.Delete {
+			delete(state.Secrets, config.JoinPath(change.Path))
+			continue
+		}
+		state.Secrets[config.JoinPath(change.Path)] = fingerprint(change.Value)
+	}
+	writeRenderState(*state)
+}
+
+func writeRenderState(state renderState) {
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		log.Fatalf("could not write render state: %v", err)
+	}
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		log.Fatalf("could not create render state directory [%s]: %v", color.Magenta(stateDir), err)
+	}
+	if ignore := filepath.Join(stateDir, ".gitignore"); !file.Exists(ignore) {
+		file.Write(ignore, "*\n")
+	}
+	if err := os.WriteFile(statePath(), data, 0600); err != nil {
+		log.Fatalf("could not write render state [%s]: %v", color.Magenta(statePath()), err)
+	}
+}
+
+// loadRenderState reads the state of the last render, or returns nil if there is none
+func loadRenderState() *renderState {
+	data, err := os.ReadFile(statePath())
+	if err != nil {
+		return nil
+	}
+	var state renderState
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		log.Errorf("could not read render state [%s], conflicts can't be detected: %v", color.Magenta(statePath()), err)
+		return nil
+	}
+	if state.Secrets == nil {
+		state.Secrets = make(map[string]string)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]string)
+	}
+	return &state
+}
+
+// conflict is a secret or file that has been changed both in its rendered copy and its source since the last render
+type conflict struct {
+	name         string
+	base         string
+	ours, theirs any
+}
+
+// resolveConflicts compares all files and secrets to be stored with the state of the last render.
+// Anything only changed in its source since is kept as it is, instead of being overwritten with the stale rendered copy.
+// Anything changed on both sides is a conflict, which either --ours or --theirs must resolve
+func resolveConflicts(files []encryptedFile, changes []sops.Change, options Options) ([]encryptedFile, []sops.Change) {
+	state := loadRenderState()
+	if state == nil {
+		log.Debugf("no render state found in [%s], can't detect conflicts", color.Magenta(statePath()))
+		return files, changes
+	}
+
+	conflicts := make([]conflict, 0)
+	// returns true if ours should be stored
+	resolve := func(name string, base string, known bool, ours, theirs any) bool {
+		switch {
+		case !known:
+			return true // rendered before the secret existed, nothing to compare with
+		case fingerprint(ours) == base:
+			log.Infof("[%s] has only been changed in its source since the last render, keeping it", color.Magenta(name))
+			return false
+		case fingerprint(theirs) == base:
+			return true
+		}
+		conflicts = append(conflicts, conflict{name: name, base: base, ours: ours, theirs: theirs})
+		return !options.Theirs
+	}
+
+	resolvedFiles := make([]encryptedFile, 0, len(files))
+	for _, f := range files {
+		base, known := state.Files[f.source]
+		if resolve(f.source, base, known, f.content, f.decrypted) {
+			resolvedFiles = append(resolvedFiles, f)
+		}
+	}
+	resolvedChanges := make([]sops.Change, 0, len(changes))
+	for _, change := range changes {
+		if change.Delete {
+			resolvedChanges = append(resolvedChanges, change)
+			continue
+		}
+		key := config.JoinPath(change.Path)
+		base, known := state.Secrets[key]
+		theirs, _ := config.Lookup(key)
+		if resolve(key, base, known, change.Value, theirs) {
+			resolvedChanges = append(resolvedChanges, change)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		for _, c := range conflicts {
+			base := "(empty)"
+			if len(c.base) > 0 {
+				base = "(sha256:" + c.base[:8] + ")"
+			}
+			log.Errorf("conflict [%s]: base %s, ours %s, theirs %s", color.Red(c.name), base, mask(c.ours), mask(c.theirs))
+		}
+		switch {
+		case options.Ours:
+			log.Infof("resolving %d conflict(s) with the rendered copies (--ours)", len(conflicts))
+		case options.Theirs:
+			log.Infof("resolving %d conflict(s) with the sources (--theirs)", len(conflicts))
+		default:
+			log.Fatalf("%d secret(s) have been changed both in their rendered copy and their source since the last render, "+
+				"use --ours to store the rendered copies or --theirs to keep the sources", len(conflicts))
+		}
+	}
+	return resolvedFiles, resolvedChanges
+}
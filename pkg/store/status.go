@@ -0,0 +1,97 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/util/file"
+	"github.com/JamesClonk/plato/pkg/util/log"
+	"github.com/spf13/viper"
+)
+
+// Exit codes of "plato status", combined bitwise if there are several issues
+const (
+	StatusTainted        = 1 << iota // the taint marker exists
+	StatusPendingSecrets             // generated secrets differ from secrets.yaml
+	StatusStaleRenders               // templates or values are newer than their rendered copies
+	StatusOutdatedFiles              // rendered .sops_enc plaintexts differ from their encrypted source
+)
+
+// Status describes everything that still needs to be rendered or stored
+type Status struct {
+	Tainted        bool
+	TaintReasons   []string
+	PendingSecrets []string // value paths
+	StaleRenders   []string // source files
+	OutdatedFiles  []string // .sops_enc files
+}
+
+// ExitCode returns 0 if everything is up to date, or the bitwise combination of all Status* codes that apply
+func (s Status) ExitCode() int {
+	code := 0
+	if s.Tainted {
+		code |= StatusTainted
+	}
+	if len(s.PendingSecrets) > 0 {
+		code |= StatusPendingSecrets
+	}
+	if len(s.StaleRenders) > 0 {
+		code |= StatusStaleRenders
+	}
+	if len(s.OutdatedFiles) > 0 {
+		code |= StatusOutdatedFiles
+	}
+	return code
+}
+
+// GetStatus compares templates, rendered files, generated secrets and the encrypted sources with each other
+func GetStatus() Status {
+	var status Status
+	status.TaintReasons, status.Tainted = TaintReasons()
+
+	changes, _ := generatedSecrets()
+	for _, change := range changes {
+		status.PendingSecrets = append(status.PendingSecrets, config.JoinPath(change.Path))
+	}
+	status.StaleRenders = staleRenders()
+	for _, f := range changedEncryptedFiles() {
+		status.OutdatedFiles = append(status.OutdatedFiles, f.source)
+	}
+	return status
+}
+
+// staleRenders returns all templates that haven't been rendered yet or have been modified since,
+// and plato.yaml or secrets.yaml if they have been modified since the last render
+func staleRenders() []string {
+	stale := make([]string, 0)
+	if info, err := os.Stat(statePath()); err == nil {
+		for _, filename := range []string{viper.ConfigFileUsed(), config.SecretsFile()} {
+			if source, err := os.Stat(filename); err == nil && source.ModTime().After(info.ModTime()) {
+				stale = append(stale, filename)
+			}
+		}
+	}
+
+	err := filepath.Walk(config.DirSource(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// skip directories and .symlink markers, which are rendered as symlinks to their original file
+		if info.IsDir() || filepath.Ext(path) == ".symlink" || file.Exists(path+".symlink") {
+			return nil
+		}
+		baseFilename := strings.TrimPrefix(path, config.DirSource()+string(os.PathSeparator))
+		renderedFilename := strings.TrimSuffix(filepath.Join(config.DirTarget(), baseFilename), ".sops_enc")
+		rendered, err := os.Lstat(renderedFilename)
+		if err != nil || info.ModTime().After(rendered.ModTime()) {
+			stale = append(stale, path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("could not compare templates with their rendered copies: %v", err)
+	}
+	return stale
+}
@@ -4,22 +4,119 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
 	"github.com/JamesClonk/plato/pkg/util/color"
-	"github.com/JamesClonk/plato/pkg/util/command"
 	"github.com/JamesClonk/plato/pkg/util/dir"
 	"github.com/JamesClonk/plato/pkg/util/file"
 	"github.com/JamesClonk/plato/pkg/util/log"
-	"github.com/spf13/viper"
+	"github.com/JamesClonk/plato/pkg/util/ordered"
+	"github.com/JamesClonk/plato/pkg/util/terminal"
 )
 
-func StoreGeneratedSecrets() {
+// Options contains all command line flags of "plato store-secrets"
+type Options struct {
+	Prune  bool // --prune, remove keys below 'plato.secrets_namespace' whose file no longer exists
+	DryRun bool // --dry-run, only show what would be stored
+	Yes    bool // --yes, don't ask for confirmation
+	Ours   bool // --ours, resolve conflicts with the rendered copies
+	Theirs bool // --theirs, resolve conflicts with the sources
+}
+
+// StoreGeneratedSecrets stores all generated secrets back into secrets.yaml and re-encrypts all *.sops_enc files.
+// Nothing is written before a summary of all changes has been shown and confirmed
+func StoreGeneratedSecrets(options Options) {
+	if options.Ours && options.Theirs {
+		log.Fatalf("--ours and --theirs can't be used together")
+	}
+	if options.Prune && len(config.SecretsNamespace()) == 0 {
+		log.Fatalf("'plato.secrets_namespace' must be set to prune secrets, plato won't touch anything outside of it")
+	}
 	log.Infof("storing secrets back into [%s] ...", color.Magenta(config.SecretsFile()))
 
-	// re-encrypt all former .sops_enc files back to their original location
-	err := filepath.Walk(config.DirSource(), func(path string, info os.FileInfo, err error) error {
+	// find all former .sops_enc files to re-encrypt, and all changed */secrets files to store back into secrets.yaml
+	files := changedEncryptedFiles()
+	changes, paths := generatedSecrets()
+	files, changes = resolveConflicts(files, changes, options)
+	changes = withRotations(changes)
+	if options.Prune {
+		changes = append(changes, pruneSecrets(staleSecrets(paths))...)
+	}
+
+	if len(files) == 0 && len(changes) == 0 {
+		log.Infof("nothing to store, all secrets are up to date")
+		if !options.DryRun {
+			RemoveTaint()
+		}
+		return
+	}
+	summarize(files, changes)
+	if options.DryRun {
+		log.Infof("dry run, nothing has been stored")
+		return
+	}
+	if !options.Yes && !terminal.Confirm(fmt.Sprintf("Re-encrypt %d file(s) and store %d change(s) into [%s]?", len(files), len(changes), config.SecretsFile())) {
+		log.Infof("nothing has been stored")
+		return
+	}
+
+	for _, f := range files {
+		log.Debugf("encrypt file [%s] into [%s]", color.Magenta(f.rendered), color.Magenta(f.source))
+		data, err := sops.Encrypt(f.source, f.content)
+		if err != nil {
+			log.Fatalf("could not encrypt file [%s]: %v", color.Magenta(f.rendered), err)
+		}
+		file.Write(f.source, string(data))
+	}
+	// store all changes into secrets.yaml in one go
+	if err := storeSecrets(changes); err != nil {
+		log.Fatalf("could not store secrets into [%s]: %v", color.Magenta(config.SecretsFile()), err)
+	}
+	updateRenderState(files, changes)
+	removeRotations()
+
+	// delete temporary .secrets-updated marker file to remove gitrepo taint
+	RemoveTaint()
+}
+
+// encryptedFile is a rendered file to be re-encrypted back to its .sops_enc source
+type encryptedFile struct {
+	source    string
+	rendered  string
+	content   []byte
+	decrypted []byte
+}
+
+// changedEncryptedFiles returns all former .sops_enc files whose rendered content differs from their source
+func changedEncryptedFiles() []encryptedFile {
+	files := make([]encryptedFile, 0)
+	err := walkEncryptedFiles(func(source, rendered string) error {
+		// check if content has changed, no need to re-encrypt the file back otherwise (avoids unnecessary git spam)
+		decrypted, err := sops.Decrypt(source)
+		if err != nil {
+			log.Errorf("could not decrypt file [%s]", color.Magenta(source))
+			return err
+		}
+		content := file.Read(rendered)
+		if string(decrypted) == content {
+			// content matches, don't re-encrypt!
+			return nil
+		}
+		files = append(files, encryptedFile{source: source, rendered: rendered, content: []byte(content), decrypted: decrypted})
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("could not re-encrypt *.sops_enc files from [%s]: %v", color.Magenta(config.DirSource()), err)
+	}
+	return files
+}
+
+// walkEncryptedFiles calls fn for every .sops_enc file in 'plato.source' that has a rendered copy
+func walkEncryptedFiles(fn func(source, rendered string) error) error {
+	return filepath.Walk(config.DirSource(), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -32,93 +129,155 @@ func StoreGeneratedSecrets() {
 				// doesnt' exist, we can't re-encrypt and re-store it.. obviously!
 				return nil
 			}
-
-			// check if content has changed, no need to re-encrypt the file back otherwise (avoids unnecessary git spam)
-			decrypted, err := command.ExecOutput([]string{"sops", "-d", path})
-			if err != nil {
-				log.Errorf("could not decrypt file [%s]", color.Magenta(path))
-				return err
-			}
-			if decrypted == file.Read(renderedFilename) {
-				// content matches, don't re-encrypt!
-				return nil
-			}
-
-			log.Debugf("encrypt file [%s] into [%s]", color.Magenta(renderedFilename), color.Magenta(path))
-			data, err := command.ExecOutput([]string{"sops", "-e", "--input-type", "binary", renderedFilename})
-			if err != nil {
-				log.Errorf("could not encrypt file [%s]", color.Magenta(renderedFilename))
-				return err
-			}
-			file.Write(path, data)
+			return fn(path, renderedFilename)
 		}
 		return nil
 	})
+}
+
+// generatedSecrets collects the changes of all */secrets files, and the value paths of all of them whether changed or not
+func generatedSecrets() ([]sops.Change, [][]string) {
+	changes := make([]sops.Change, 0)
+	paths := make([][]string, 0)
+	walkGeneratedSecrets(func(path string, info os.FileInfo, valuePath []string) {
+		paths = append(paths, valuePath)
+		if change, ok := processFile(path, info, valuePath); ok {
+			changes = append(changes, change)
+		}
+	})
+	return changes, paths
+}
+
+// walkGeneratedSecrets calls fn for every file in 'plato.secrets' to be stored, together with its value path
+func walkGeneratedSecrets(fn func(path string, info os.FileInfo, valuePath []string)) {
+	if !dir.Exists(config.DirGeneratedSecrets()) {
+		return
+	}
+	secretsMap, err := config.SecretsMap()
 	if err != nil {
-		log.Fatalf("could not re-encrypt *.sops_enc files from [%s]: %v", color.Magenta(config.DirSource()), err)
+		log.Fatalf("invalid secrets map: %s", color.Red("%v", err))
 	}
 
-	// go through all */secrets files
-	if dir.Exists(config.DirGeneratedSecrets()) {
-		err = filepath.Walk(config.DirGeneratedSecrets(), func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			// skip directories
-			if info.IsDir() {
-				return nil
-			}
-			return processFile(path, info) // store file contents back into secrets.yaml
-		})
+	err = filepath.Walk(config.DirGeneratedSecrets(), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Fatalf("could not work through [%s]: %v", color.Magenta(config.DirGeneratedSecrets()), err)
+			return err
+		}
+		// skip directories
+		if info.IsDir() {
+			return nil
 		}
+		if excluded(path) {
+			return nil
+		}
+		fn(path, info, secretPath(path, secretsMap))
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("could not work through [%s]: %v", color.Magenta(config.DirGeneratedSecrets()), err)
 	}
-
-	// delete temporary .secrets-updated marker file to remove gitrepo taint
-	_ = os.Remove(".secrets-updated")
 }
 
-func processFile(path string, info os.FileInfo) error {
-	// exclude files we obviously didn't template and/or want to store in secrets.yaml
-	ext := filepath.Ext(path)
-	if ext == ".md" ||
-		ext == ".txt" ||
-		ext == ".zip" ||
-		ext == ".tgz" ||
-		ext == ".gz" ||
-		strings.HasSuffix(path, ".tar.gz") {
+// storeSecrets applies all changes to secrets.yaml, which gets decrypted and encrypted only once.
+// Either all of them are stored or none, there are no intermediate states of the file if something goes wrong
+func storeSecrets(changes []sops.Change) error {
+	if len(changes) == 0 {
 		return nil
 	}
+	for _, change := range changes {
+		log.Debugf("store secret [%s]", color.Magenta(config.JoinPath(change.Path)))
+	}
+	log.Debugf("store %d secret(s) into [%s]", len(changes), color.Magenta(config.SecretsFile()))
+	return sops.Update(config.SecretsFile(), changes...)
+}
 
-	filename := filepath.Base(path)
-	data := file.Read(path)
+// secretPath returns the value path a generated secret is stored under. Unless there is an explicit entry in
+// 'plato.secrets_map', the filename is split on 'plato.secrets_separator'. With 'plato.secrets_directories'
+// subdirectories become nested keys too, so "prod/db/tls.crt" ends up under "prod.db.tls.crt" instead of "tls.crt"
+func secretPath(path string, secretsMap map[string]string) []string {
+	relative, err := filepath.Rel(config.DirGeneratedSecrets(), path)
+	if err != nil {
+		relative = filepath.Base(path)
+	}
+	relative = filepath.ToSlash(relative)
+	if valuePath, ok := secretsMap[relative]; ok {
+		return config.SplitPath(valuePath)
+	}
+
+	parts := strings.Split(relative, "/")
+	filename := parts[len(parts)-1]
+	nested := append(parts[:len(parts)-1], strings.Split(filename, config.SecretsSeparator())...)
+	if len(parts) == 1 || config.SecretsDirectories() {
+		return nested
+	}
+	valuePath := strings.Split(filename, config.SecretsSeparator())
+	log.Warnf("[%s] is stored under [%s], set 'plato.secrets_directories' to store it under [%s]",
+		color.Magenta(relative), color.Magenta(config.JoinPath(valuePath)), color.Magenta(config.JoinPath(nested)))
+	return valuePath
+}
+
+// processFile returns the change to store back into secrets.yaml under the given value path, if the file content has changed
+func processFile(path string, info os.FileInfo, valuePath []string) (sops.Change, bool) {
+	if excluded(path) {
+		return sops.Change{}, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("could not read file [%s]: %s", color.Magenta(path), color.Red("%v", err))
+	}
+	order := ordered.NewOrder()
+	value := secretValue(path, data, order)
 
 	// check if data has actually changed, no need to store it back otherwise (avoids unnecessary git spam)
-	if data == viper.GetString(filename) {
+	if unchanged(config.JoinPath(valuePath), value, data) {
 		// content matches, don't store!
-		return nil
+		return sops.Change{}, false
 	}
 
-	// turn data into single-line JSON compatible string (needed for SOPS, it can only take JSON input)
-	data = strings.Replace(data, "\r", "", -1) // have to remove windows garbage if present
-	data = strings.Replace(data, "\n", `\n`, -1)
-	data = strings.Replace(data, `"`, `\"`, -1)
-	data = strings.Trim(data, "\n")
+	return sops.Change{Path: valuePath, Value: value, Order: order}, true
+}
+
+// excluded checks for files we obviously didn't template and/or want to store in secrets.yaml
+func excluded(path string) bool {
+	ext := filepath.Ext(path)
+	return filepath.Base(path) == legacyStateFilename ||
+		filepath.Base(path) == rotationFilename ||
+		ext == ".md" ||
+		ext == ".txt" ||
+		ext == ".zip" ||
+		ext == ".tgz" ||
+		ext == ".gz" ||
+		strings.HasSuffix(path, ".tar.gz")
+}
+
+// secretValue returns the value to store for a generated secret: binary content base64-encoded with a marker,
+// .yaml and .json files as subtrees if 'plato.secrets_structured' is enabled, remembering their key order in the given order,
+// and text as a plain string otherwise
+func secretValue(path string, data []byte, order *ordered.Order) any {
+	if config.IsBinary(data) {
+		return config.EncodeBinary(data)
+	}
 
-	// prepare command value, for format see https://github.com/getsops/sops#set-a-sub-part-in-a-document-tree
-	pathParts := strings.SplitN(filename, ".", -1)
-	var value string
-	for _, part := range pathParts {
-		value = fmt.Sprintf("%s[\"%s\"]", value, part)
+	text := strings.Replace(string(data), "\r", "", -1) // have to remove windows garbage if present
+	ext := filepath.Ext(path)
+	if config.StructuredSecrets() && (ext == ".yaml" || ext == ".yml" || ext == ".json") {
+		object, err := ordered.Unmarshal([]byte(text), order)
+		if err != nil {
+			log.Errorf("could not parse [%s], storing it as a string: %s", color.Magenta(path), color.Red("%v", err))
+			return text
+		}
+		return object
 	}
-	value = fmt.Sprintf("%s \"%s\"", value, data)
+	return text
+}
 
-	// set value in-place
-	log.Debugf("store secret [%s]", color.Magenta(filename))
-	if err := command.Exec([]string{"sops", "--set", value, config.SecretsFile()}); err != nil {
-		log.Errorf("could not store secret [%s]", color.Magenta(filename))
-		return err
+// unchanged checks if the value currently stored under the given path matches the generated secret
+func unchanged(valuePath string, value any, data []byte) bool {
+	switch value.(type) {
+	case map[string]any, []any:
+		current, _ := config.Lookup(valuePath)
+		return reflect.DeepEqual(current, value)
 	}
-	return nil
+	// strings and binary values are compared to the raw content, LoadSecrets already decoded them
+	return config.LookupString(valuePath) == string(data)
 }
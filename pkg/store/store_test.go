@@ -6,8 +6,10 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
 	"github.com/JamesClonk/plato/pkg/util/command"
 	"github.com/JamesClonk/plato/pkg/util/dir"
 	"github.com/JamesClonk/plato/pkg/util/file"
@@ -60,7 +62,7 @@ func Test_StoreGeneratedSecrets(t *testing.T) {
 	dir.Create(filepath.Dir(sourceFile))
 	file.Write(sourceFile, content)
 
-	StoreGeneratedSecrets()
+	StoreGeneratedSecrets(Options{Yes: true})
 	assert.True(t, file.Exists(targetFile))
 	data := file.Read(targetFile)
 	assert.True(t, strings.Contains(data, `"recipient": "age1yapc0k0tfz8cketuldrjq3vyuzne4587zmf3d2ejypaftg95yvrs8r44yh",`))
@@ -109,9 +111,11 @@ users:
 	info, err := os.Lstat(testFile)
 	assert.NoError(t, err)
 
-	// processFile() should store back into secrets.yaml
-	err = processFile(testFile, info)
-	assert.NoError(t, err)
+	// processFile() should return the change to store back into secrets.yaml
+	change, ok := processFile(testFile, info, secretPath(testFile, nil))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"test", "myconfig"}, change.Path)
+	assert.NoError(t, storeSecrets([]sops.Change{change}))
 
 	// read secrets
 	decryptedSecrets, err := command.ExecOutput([]string{"sops", "-d", "secrets.yaml"})
@@ -124,7 +128,7 @@ users:
 	viper.Reset()
 	viper.SetConfigType("yaml")
 	config.LoadSecrets()
-	assert.Equal(t, data, viper.GetString("test.myconfig"))
+	assert.Equal(t, data, config.LookupString("test.myconfig"))
 }
 
 func Test_processFile_filetype_exclusion(t *testing.T) {
@@ -141,8 +145,8 @@ func Test_processFile_filetype_exclusion(t *testing.T) {
 	assert.NoError(t, err)
 
 	// processFile() should not store a markdown file back into secrets.yaml
-	err = processFile(testFile, info)
-	assert.NoError(t, err)
+	_, ok := processFile(testFile, info, secretPath(testFile, nil))
+	assert.False(t, ok)
 	assert.True(t, file.Exists(testFile))
 
 	// read secrets
@@ -156,3 +160,453 @@ func Test_processFile_filetype_exclusion(t *testing.T) {
 	config.LoadSecrets()
 	assert.Equal(t, "", viper.GetString("test.md"))
 }
+
+// countingBackend counts the decrypt/encrypt round trips against secrets.yaml
+type countingBackend struct {
+	*sops.Fake
+	updates int
+}
+
+func (c *countingBackend) Update(filename string, changes ...sops.Change) error {
+	c.updates++
+	return c.Fake.Update(filename, changes...)
+}
+
+func Test_storeSecrets_single_update(t *testing.T) {
+	defer sops.SetBackend(sops.GetBackend())
+	backend := &countingBackend{Fake: sops.NewFake()}
+	sops.SetBackend(backend)
+
+	dir.Remove(config.DirGeneratedSecrets())
+	dir.Create(config.DirGeneratedSecrets())
+	t.Cleanup(func() { dir.Remove(config.DirGeneratedSecrets()) })
+	file.Write(filepath.Join(config.DirGeneratedSecrets(), "first.secret"), "one")
+	file.Write(filepath.Join(config.DirGeneratedSecrets(), "second.secret"), "two\r\n")
+	file.Write(filepath.Join(config.DirGeneratedSecrets(), "README.md"), "ignored")
+
+	changes, paths := generatedSecrets()
+	assert.Len(t, changes, 2)
+	assert.Len(t, paths, 2)
+	assert.NoError(t, storeSecrets(changes))
+	assert.Equal(t, 1, backend.updates)
+	assert.Equal(t, "first:\n    secret: one\nsecond:\n    secret: |\n        two\n", string(backend.Files[config.SecretsFile()]))
+
+	// nothing changed, nothing to store
+	assert.NoError(t, storeSecrets(nil))
+	assert.Equal(t, 1, backend.updates)
+}
+
+func Test_secretPath(t *testing.T) {
+	defer config.Set("plato.secrets_separator", nil)
+	secrets := config.DirGeneratedSecrets()
+
+	// subdirectories are ignored by default
+	assert.Equal(t, []string{"db", "password"}, secretPath(filepath.Join(secrets, "prod", "db.password"), nil))
+
+	config.Set("plato.secrets_directories", true)
+	t.Cleanup(func() { config.Set("plato.secrets_directories", nil) })
+
+	assert.Equal(t, []string{"registry", "password"}, secretPath(filepath.Join(secrets, "registry.password"), nil))
+	assert.Equal(t, []string{"prod", "db", "password"}, secretPath(filepath.Join(secrets, "prod", "db", "password"), nil))
+	assert.Equal(t, []string{"prod", "tls", "ca", "crt"}, secretPath(filepath.Join(secrets, "prod", "tls.ca.crt"), nil))
+
+	// explicit mapping for exceptions
+	secretsMap := map[string]string{"prod/tls.ca.crt": `tls.prod.ca\.crt`}
+	assert.Equal(t, []string{"tls", "prod", "ca.crt"}, secretPath(filepath.Join(secrets, "prod", "tls.ca.crt"), secretsMap))
+
+	// configurable separator
+	config.Set("plato.secrets_separator", "/")
+	assert.Equal(t, []string{"prod", "tls.ca.crt"}, secretPath(filepath.Join(secrets, "prod", "tls.ca.crt"), nil))
+	config.Set("plato.secrets_separator", "__")
+	assert.Equal(t, []string{"prod", "tls", "ca.crt"}, secretPath(filepath.Join(secrets, "prod", "tls__ca.crt"), nil))
+}
+
+func Test_processFile_binary_and_structured(t *testing.T) {
+	// work on a copy of secrets.yaml, the fixture must not be changed
+	secretsFile := filepath.Join(t.TempDir(), "secrets.yaml")
+	data, err := os.ReadFile("secrets.yaml")
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(secretsFile, data, 0600))
+	t.Setenv("PLATO_SECRETS_FILE", secretsFile)
+	config.LoadSecrets()
+	t.Cleanup(func() {
+		os.Unsetenv("PLATO_SECRETS_FILE")
+		config.LoadSecrets()
+	})
+	assert.Equal(t, secretsFile, config.SecretsFile())
+
+	defer config.Set("plato.secrets_structured", nil)
+	config.Set("plato.secrets_structured", true)
+	dir.Create(config.DirGeneratedSecrets())
+	t.Cleanup(func() { dir.Remove(config.DirGeneratedSecrets()) })
+
+	keystore := filepath.Join(config.DirGeneratedSecrets(), "app.keystore")
+	keystoreData := []byte{0xfe, 0xed, 0xfe, 0xed, 0x00, 0x00, 0x00, 0x02, '\r', '\n'}
+	assert.NoError(t, os.WriteFile(keystore, keystoreData, 0600))
+	structured := filepath.Join(config.DirGeneratedSecrets(), "app.config.json")
+	file.Write(structured, `{"zebra": "back\\slash\ttab", "alpha": [1, 2]}`)
+	escaped := filepath.Join(config.DirGeneratedSecrets(), "app.escaped")
+	file.Write(escaped, "back\\slash \"quoted\"\ttab\x01control\n")
+
+	changes := make([]sops.Change, 0)
+	for _, path := range []string{keystore, structured, escaped} {
+		info, err := os.Lstat(path)
+		assert.NoError(t, err)
+		change, ok := processFile(path, info, secretPath(path, nil))
+		assert.True(t, ok)
+		changes = append(changes, change)
+	}
+	assert.Equal(t, "!!binary /u3+7QAAAAINCg==", changes[0].Value)
+	assert.IsType(t, map[string]any{}, changes[1].Value)
+	assert.NoError(t, storeSecrets(changes))
+
+	decryptedSecrets, err := command.ExecOutput([]string{"sops", "-d", secretsFile})
+	assert.NoError(t, err)
+	assert.Contains(t, decryptedSecrets, "app:\n  keystore: '!!binary /u3+7QAAAAINCg=='\n  config:\n    json:\n      zebra: \"back\\\\slash\\ttab\"\n      alpha:\n        - 1\n")
+
+	// LoadSecrets decodes binary values transparently
+	config.LoadSecrets()
+	assert.Equal(t, string(keystoreData), config.LookupString("app.keystore"))
+	assert.Equal(t, "back\\slash\ttab", config.LookupString("app.config.json.zebra"))
+	assert.Equal(t, "back\\slash \"quoted\"\ttab\x01control\n", config.LookupString("app.escaped"))
+
+	// nothing changed, nothing to store
+	for _, path := range []string{keystore, structured, escaped} {
+		info, err := os.Lstat(path)
+		assert.NoError(t, err)
+		_, ok := processFile(path, info, secretPath(path, nil))
+		assert.False(t, ok, path)
+	}
+}
+
+func Test_staleSecrets(t *testing.T) {
+	defer sops.SetBackend(sops.GetBackend())
+	fake := sops.NewFake()
+	sops.SetBackend(fake)
+	_, err := fake.Encrypt(config.SecretsFile(), []byte(`
+registry:
+  password: outside of the namespace
+generated:
+  prod:
+    db:
+      password: still there
+      user: removed
+    tls:
+      ca.crt: still there
+  staging:
+    db:
+      password: removed
+  app:
+    config:
+      structured: still there
+`))
+	assert.NoError(t, err)
+
+	defer config.Set("plato.secrets_namespace", nil)
+	config.Set("plato.secrets_namespace", "generated")
+	paths := [][]string{
+		{"generated", "prod", "db", "password"},
+		{"generated", "prod", "tls", "ca.crt"},
+		{"generated", "app", "config"},
+		{"generated", "new", "secret"},
+	}
+	assert.Equal(t, [][]string{
+		{"generated", "prod", "db", "user"},
+		{"generated", "staging"},
+	}, staleSecrets(paths))
+
+	config.Set("plato.secrets_namespace", "nothing.here")
+	assert.Empty(t, staleSecrets(paths))
+}
+
+func Test_StoreGeneratedSecrets_dry_run(t *testing.T) {
+	config.InitConfig() // other tests reset viper
+	dir.Create(config.DirGeneratedSecrets())
+	t.Cleanup(func() { dir.Remove(config.DirGeneratedSecrets()) })
+	file.Write(filepath.Join(config.DirGeneratedSecrets(), "dry.run"), "not stored")
+
+	before := file.Read("secrets.yaml")
+	StoreGeneratedSecrets(Options{DryRun: true})
+	assert.Equal(t, before, file.Read("secrets.yaml"))
+}
+
+func Test_mask(t *testing.T) {
+	assert.Equal(t, "(6 bytes, sha256:2bb80d53)", mask("secret"))
+	assert.Equal(t, "(6 bytes, sha256:2bb80d53)", mask([]byte("secret")))
+	assert.Equal(t, "(7 bytes, sha256:015abd7f)", mask(map[string]any{"a": 1}))
+	assert.Equal(t, "(empty)", mask(nil))
+}
+
+func Test_writeRenderState(t *testing.T) {
+	t.Cleanup(func() { _ = os.RemoveAll(stateDir) })
+	writeRenderState(renderState{Secrets: map[string]string{"a": fingerprint("secret")}})
+
+	// the render state must never end up in the target directory or in git
+	assert.Equal(t, "*\n", file.Read(filepath.Join(stateDir, ".gitignore")))
+	info, err := os.Stat(statePath())
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.Equal(t, fingerprint("secret"), loadRenderState().Secrets["a"])
+}
+
+func Test_valueBytes_keeps_value(t *testing.T) {
+	encoded := config.EncodeBinary([]byte{0xff, 0x00})
+	value := map[string]any{"keystore": encoded, "list": []any{encoded}}
+	data, ok := valueBytes(value)
+	assert.True(t, ok)
+	assert.NotContains(t, string(data), "!!binary")
+
+	// hashing must not decode the value that is still to be stored
+	assert.Equal(t, encoded, value["keystore"])
+	assert.Equal(t, []any{encoded}, value["list"])
+}
+
+func Test_resolveConflicts(t *testing.T) {
+	t.Cleanup(func() { _ = os.RemoveAll(stateDir) })
+	config.Set("conflicts.pulled", "theirs")
+	config.Set("conflicts.edited", "base")
+	config.Set("conflicts.both", "theirs")
+
+	// without a render state there is nothing to compare with
+	_ = os.Remove(statePath())
+	changes := []sops.Change{{Path: []string{"conflicts", "both"}, Value: "ours"}}
+	_, resolved := resolveConflicts(nil, changes, Options{})
+	assert.Equal(t, changes, resolved)
+
+	writeRenderState(renderState{
+		Secrets: map[string]string{
+			"conflicts.pulled": fingerprint("base"),
+			"conflicts.edited": fingerprint("base"),
+			"conflicts.both":   fingerprint("base"),
+		},
+		Files: map[string]string{"input/both.sops_enc": fingerprint([]byte("base"))},
+	})
+	files := []encryptedFile{
+		{source: "input/both.sops_enc", content: []byte("ours"), decrypted: []byte("theirs")},
+		{source: "input/unknown.sops_enc", content: []byte("ours"), decrypted: []byte("theirs")},
+	}
+	changes = []sops.Change{
+		{Path: []string{"conflicts", "pulled"}, Value: "base"}, // only changed in secrets.yaml, keep it
+		{Path: []string{"conflicts", "edited"}, Value: "ours"}, // only changed in the rendered copy, store it
+		{Path: []string{"conflicts", "both"}, Value: "ours"},   // changed on both sides
+		{Path: []string{"conflicts", "removed"}, Delete: true},
+	}
+
+	resolvedFiles, resolved := resolveConflicts(files, changes, Options{Ours: true})
+	assert.Equal(t, files, resolvedFiles)
+	assert.Equal(t, changes[1:], resolved)
+
+	resolvedFiles, resolved = resolveConflicts(files, changes, Options{Theirs: true})
+	assert.Equal(t, files[1:], resolvedFiles)
+	assert.Equal(t, []sops.Change{changes[1], changes[3]}, resolved)
+
+	// stored changes become the new base
+	updateRenderState(files[:1], changes[2:3])
+	state := loadRenderState()
+	assert.Equal(t, fingerprint("ours"), state.Secrets["conflicts.both"])
+	assert.Equal(t, fingerprint([]byte("ours")), state.Files["input/both.sops_enc"])
+}
+
+func Test_Taint(t *testing.T) {
+	RemoveTaint()
+	t.Cleanup(RemoveTaint)
+	_, tainted := TaintReasons()
+	assert.False(t, tainted)
+
+	Taint("generated new SSH keypair [ssh]")
+	Taint("pending secret [ssh.private_key]", "generated new SSH keypair [ssh]")
+	reasons, tainted := TaintReasons()
+	assert.True(t, tainted)
+	assert.Equal(t, []string{"generated new SSH keypair [ssh]", "pending secret [ssh.private_key]"}, reasons)
+	assert.True(t, strings.HasPrefix(file.Read(".secrets-updated"), "# generated secrets have not been stored yet"))
+
+	RemoveTaint()
+	_, tainted = TaintReasons()
+	assert.False(t, tainted)
+}
+
+func Test_TaintPending_unloaded(t *testing.T) {
+	config.SetUsedKeysFunc(func() ([][]string, bool) { return [][]string{{"loaded"}}, false })
+	config.InitConfig()
+	t.Cleanup(func() {
+		config.SetUsedKeysFunc(nil)
+		config.InitConfig()
+	})
+	RemoveTaint()
+	t.Cleanup(RemoveTaint)
+	dir.Create(config.DirGeneratedSecrets())
+	t.Cleanup(func() { dir.Remove(config.DirTarget()) })
+
+	// secrets that haven't been loaded can't be compared with secrets.yaml
+	file.Write(filepath.Join(config.DirGeneratedSecrets(), "loaded.pending"), "not stored yet")
+	file.Write(filepath.Join(config.DirGeneratedSecrets(), "unloaded.pending"), "unknown")
+	TaintPending()
+	reasons, tainted := TaintReasons()
+	assert.True(t, tainted)
+	assert.Equal(t, []string{"pending secret [loaded.pending]"}, reasons)
+}
+
+func Test_GetStatus(t *testing.T) {
+	config.InitConfig() // other tests reset viper
+	RemoveTaint()
+	t.Cleanup(RemoveTaint)
+	dir.Remove(config.DirTarget())
+	t.Cleanup(func() { dir.Remove(config.DirTarget()) })
+
+	// nothing rendered yet
+	status := GetStatus()
+	assert.NotEmpty(t, status.StaleRenders)
+	assert.Empty(t, status.PendingSecrets)
+	assert.Equal(t, StatusStaleRenders, status.ExitCode())
+
+	dir.Create(config.DirGeneratedSecrets())
+	file.Write(filepath.Join(config.DirGeneratedSecrets(), "status.pending"), "not stored yet")
+	TaintPending()
+	status = GetStatus()
+	assert.True(t, status.Tainted)
+	assert.Equal(t, []string{"pending secret [status.pending]"}, status.TaintReasons)
+	assert.Equal(t, []string{"status.pending"}, status.PendingSecrets)
+	assert.Equal(t, StatusTainted|StatusPendingSecrets|StatusStaleRenders, status.ExitCode())
+
+	assert.Equal(t, 0, Status{}.ExitCode())
+	assert.Equal(t, StatusOutdatedFiles, Status{OutdatedFiles: []string{"a.sops_enc"}}.ExitCode())
+}
+
+func Test_secrets(t *testing.T) {
+	defer sops.SetBackend(sops.GetBackend())
+	fake := sops.NewFake()
+	sops.SetBackend(fake)
+	_, err := fake.Encrypt("secrets.yaml", []byte("registry:\n  password: secret\n  username: admin\nempty: {}\n"))
+	assert.NoError(t, err)
+
+	paths, err := ListSecrets("secrets.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"registry.password", "registry.username", "empty"}, paths)
+
+	assert.NoError(t, SetSecret("secrets.yaml", `ingress.nginx\.org/ssl`, "-", []byte("line\r\n")))
+	assert.NoError(t, SetSecret("secrets.yaml", "keystore", "app.jks", []byte{0xfe, 0xed, 0x00}))
+	value, err := GetSecret("secrets.yaml", `ingress.nginx\.org/ssl`, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "line\n", value)
+	value, err = GetSecret("secrets.yaml", "keystore", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "\xfe\xed\x00", value)
+	_, err = GetSecret("secrets.yaml", "does.not.exist", nil)
+	assert.Error(t, err)
+
+	assert.NoError(t, RemoveSecret("secrets.yaml", "registry.username"))
+	assert.Error(t, RemoveSecret("secrets.yaml", "registry.username"))
+	paths, err = ListSecrets("secrets.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"empty", `ingress.nginx\.org/ssl`, "keystore", "registry.password"}, paths)
+
+	t.Setenv("EDITOR", "sed -i -e s/password:.*/password:\\ edited/ -e /^keystore:/d")
+	assert.NoError(t, EditSecrets("secrets.yaml"))
+	value, err = GetSecret("secrets.yaml", "registry.password", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "edited", value)
+	_, err = GetSecret("secrets.yaml", "keystore", nil)
+	assert.Error(t, err)
+}
+
+func Test_secrets_literal_key(t *testing.T) {
+	defer sops.SetBackend(sops.GetBackend())
+	fake := sops.NewFake()
+	sops.SetBackend(fake)
+	_, err := fake.Encrypt("secrets.yaml", []byte("db.url: old\n"))
+	assert.NoError(t, err)
+
+	// set and get must refer to the same key, the existing literal "db.url" instead of db -> url
+	assert.NoError(t, SetSecret("secrets.yaml", "db.url", "-", []byte("new")))
+	value, err := GetSecret("secrets.yaml", "db.url", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", value)
+	paths, err := ListSecrets("secrets.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`db\.url`}, paths)
+
+	assert.NoError(t, RemoveSecret("secrets.yaml", "db.url"))
+	paths, err = ListSecrets("secrets.yaml")
+	assert.NoError(t, err)
+	assert.Empty(t, paths)
+}
+
+func Test_EditSecrets_invalid(t *testing.T) {
+	defer sops.SetBackend(sops.GetBackend())
+	fake := sops.NewFake()
+	sops.SetBackend(fake)
+	_, err := fake.Encrypt("secrets.yaml", []byte("password: secret\n"))
+	assert.NoError(t, err)
+	tmp := t.TempDir()
+
+	// the editor is opened again with the error on top, the second run fixes it
+	editor := filepath.Join(tmp, "editor")
+	assert.NoError(t, os.WriteFile(editor, []byte(`#!/bin/sh
+if grep -q "^# plato: " "$1"; then
+	sed -i -e "s/^password: \[$/password: fixed/" "$1"
+else
+	echo "password: [" > "$1"
+fi
+`), 0700))
+	t.Setenv("EDITOR", editor)
+	assert.NoError(t, EditSecrets("secrets.yaml"))
+	value, err := GetSecret("secrets.yaml", "password", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "fixed", value)
+
+	// saved again without fixing it, the edits are kept
+	t.Setenv("EDITOR", `sh -c 'echo "password: [" > "$0"'`)
+	err = EditSecrets("secrets.yaml")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "your edits have been kept in")
+	kept := strings.TrimSuffix(strings.SplitN(err.Error(), "kept in [", 2)[1], "], remove it when you're done")
+	edited, err := os.ReadFile(kept)
+	assert.NoError(t, err)
+	assert.Equal(t, "password: [\n", string(edited))
+	os.RemoveAll(filepath.Dir(kept))
+	value, err = GetSecret("secrets.yaml", "password", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "fixed", value)
+}
+
+func Test_withRotations(t *testing.T) {
+	dir.Create(config.DirTarget())
+	t.Cleanup(removeRotations)
+	removeRotations()
+	changes := []sops.Change{{Path: []string{"other"}, Value: "x"}}
+	assert.Equal(t, changes, withRotations(changes))
+
+	rotated := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	SaveRotations(map[string]any{"db.password": "new", "ssh.deploy": map[string]any{"private_key": "key"}}, rotated)
+
+	changes = []sops.Change{
+		{Path: []string{"other"}, Value: "x"},
+		{Path: []string{"ssh", "deploy", "private_key"}, Value: "generated"}, // replaced by the rotated keypair
+		{Path: []string{"ssh", "deploy", "removed"}, Delete: true},
+	}
+	assert.Equal(t, []sops.Change{
+		{Path: []string{"other"}, Value: "x"},
+		{Path: []string{"ssh", "deploy", "removed"}, Delete: true},
+		{Path: []string{"db", "password"}, Value: "new"},
+		{Path: []string{"plato_metadata", "rotated", "db.password"}, Value: "2026-10-19T12:00:00Z"},
+		{Path: []string{"ssh", "deploy"}, Value: map[string]any{"private_key": "key"}},
+		{Path: []string{"plato_metadata", "rotated", "ssh.deploy"}, Value: "2026-10-19T12:00:00Z"},
+	}, withRotations(changes))
+	assert.True(t, excluded(rotationPath()))
+}
+
+func Test_ListRecipients(t *testing.T) {
+	config.InitConfig() // other tests reset viper
+	files, err := EncryptedFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secrets.yaml", "input/infrastructure/terraform/terraform.tfstate.backup.sops_enc"}, files)
+
+	recipients, err := ListRecipients()
+	assert.NoError(t, err)
+	assert.Len(t, recipients, 2)
+	for _, f := range recipients {
+		assert.True(t, f.Rule)
+		assert.False(t, f.Diverges(), f.File)
+		assert.Equal(t, []sops.Recipient{{Type: "age", Key: "age1yapc0k0tfz8cketuldrjq3vyuzne4587zmf3d2ejypaftg95yvrs8r44yh"}}, f.Recipients)
+	}
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/log"
+)
+
+// summarize lists all files to be re-encrypted and all keys to be added, changed or removed in secrets.yaml.
+// Values are never shown, only their length and a short hash
+func summarize(files []encryptedFile, changes []sops.Change) {
+	for _, f := range files {
+		log.Infof("re-encrypt [%s] %s", color.Magenta(f.source), mask(f.content))
+	}
+	for _, change := range changes {
+		key := config.JoinPath(change.Path)
+		current, exists := config.Lookup(key)
+		switch {
+		case change.Delete:
+			log.Infof("remove [%s]", color.Red(key))
+		case exists:
+			log.Infof("change [%s] %s -> %s", color.Magenta(key), mask(current), mask(change.Value))
+		default:
+			log.Infof("add [%s] %s", color.Magenta(key), mask(change.Value))
+		}
+	}
+}
+
+// mask describes a value by its length and the first 8 hex characters of its SHA-256 hash
+func mask(value any) string {
+	data, ok := valueBytes(value)
+	if !ok {
+		return "(empty)"
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("(%d bytes, sha256:%s)", len(data), hex.EncodeToString(sum[:])[:8])
+}
+
+// fingerprint returns the SHA-256 hash of a value, or an empty string if there is no value
+func fingerprint(value any) string {
+	data, ok := valueBytes(value)
+	if !ok {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// valueBytes returns the content of a value to be hashed. Binary-marked values are decoded, maps and lists encoded as JSON
+func valueBytes(value any) ([]byte, bool) {
+	// decode a copy, DecodeBinaryValue modifies maps and lists in place and the value is still to be stored
+	switch v := config.DecodeBinaryValue(deepCopy(value)).(type) {
+	case nil:
+		return nil, false
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			encoded = []byte(fmt.Sprintf("%v", v))
+		}
+		return encoded, true
+	}
+}
+
+// deepCopy copies all maps and lists within a value
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(v))
+		for key, item := range v {
+			copied[key] = deepCopy(item)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(v))
+		for i, item := range v {
+			copied[i] = deepCopy(item)
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/JamesClonk/plato/pkg/config"
+	"github.com/JamesClonk/plato/pkg/sops"
+	"github.com/JamesClonk/plato/pkg/util/color"
+	"github.com/JamesClonk/plato/pkg/util/file"
+	"github.com/JamesClonk/plato/pkg/util/log"
+)
+
+// taintMarker marks the git repository as tainted, there are generated secrets that have not been stored yet.
+// Rendering again would generate new ones and lose the previous ones, so render refuses to run until store-secrets removes it
+const taintMarker = ".secrets-updated"
+
+const taintHeader = "# generated secrets have not been stored yet, run \"plato store-secrets\""
+
+// Taint creates the taint marker, or adds the reasons to an existing one
+func Taint(reasons ...string) {
+	existing, _ := TaintReasons()
+	for _, reason := range reasons {
+		if !slices.Contains(existing, reason) {
+			existing = append(existing, reason)
+		}
+	}
+	file.Write(taintMarker, taintHeader+"\n"+strings.Join(existing, "\n")+"\n")
+}
+
+// TaintReasons returns the reasons of the taint marker, and if it exists at all
+func TaintReasons() ([]string, bool) {
+	data, err := os.ReadFile(taintMarker)
+	if err != nil {
+		return nil, false
+	}
+	reasons := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 && !strings.HasPrefix(line, "#") {
+			reasons = append(reasons, line)
+		}
+	}
+	return reasons, true
+}
+
+// RemoveTaint deletes the taint marker
+func RemoveTaint() {
+	_ = os.Remove(taintMarker)
+}
+
+// TaintPending creates the taint marker if any of the generated secrets differ from what is stored in secrets.yaml.
+// Secrets which have not been loaded for rendering are skipped, their value in secrets.yaml is unknown
+func TaintPending() {
+	changes := make([]sops.Change, 0)
+	walkGeneratedSecrets(func(path string, info os.FileInfo, valuePath []string) {
+		if !config.Loaded(valuePath[0]) {
+			return
+		}
+		if change, ok := processFile(path, info, valuePath); ok {
+			changes = append(changes, change)
+		}
+	})
+	if len(changes) == 0 {
+		return
+	}
+	reasons := make([]string, 0, len(changes))
+	for _, change := range changes {
+		reasons = append(reasons, "pending secret ["+config.JoinPath(change.Path)+"]")
+	}
+	Taint(reasons...)
+	log.Warnf("%d generated secret(s) differ from [%s], store them with \"plato store-secrets\"", len(changes), color.Magenta(config.SecretsFile()))
+}
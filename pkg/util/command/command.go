@@ -2,16 +2,21 @@ package command
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
 	xc "os/exec"
 	"strings"
+	"time"
 
 	"github.com/JamesClonk/plato/pkg/util/color"
 	"github.com/JamesClonk/plato/pkg/util/log"
 	"github.com/JamesClonk/plato/pkg/util/terminal"
 )
 
+// waitDelay is how long a killed command may take to close its output
+var waitDelay = time.Second
+
 func Get(command []string) *xc.Cmd {
 	return xc.Command(command[0], command[1:]...)
 }
@@ -22,6 +27,16 @@ func GetInDir(command []string, dir string) *xc.Cmd {
 	return cmd
 }
 
+// GetWithContext returns a command that is killed together with all processes it started once the context is done.
+// It must be run by ExecSeparateOutput, which gives the terminal back to plato if the command took it over to prompt on it
+func GetWithContext(ctx context.Context, command []string) *xc.Cmd {
+	cmd := xc.CommandContext(ctx, command[0], command[1:]...)
+	killProcessGroup(cmd)
+	// don't wait for the output of processes that escaped the process group
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
 func ExecOutput(command []string) (string, error) {
 	return execOutput(Get(command))
 }
@@ -47,6 +62,20 @@ func execOutput(cmd *xc.Cmd) (string, error) {
 	return string(output), nil
 }
 
+// ExecSeparateOutput runs a command and returns its stdout and stderr separately
+func ExecSeparateOutput(cmd *xc.Cmd) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	restoreForeground(cmd)
+	if err != nil {
+		log.Debugf("failed command: %v", strings.Join(cmd.Args, " "))
+	}
+	return stdout.String(), stderr.String(), err
+}
+
 func runOutput(cmd *xc.Cmd) string {
 	output, err := execOutput(cmd)
 	if err != nil {
@@ -84,6 +113,13 @@ func exec(cmd *xc.Cmd) error {
 	return err
 }
 
+// ExecInteractive runs a command attached to the terminal, like an editor
+func ExecInteractive(command []string) error {
+	cmd := Get(command)
+	cmd.Stdin = os.Stdin
+	return exec(cmd)
+}
+
 func run(cmd *xc.Cmd) {
 	if err := exec(cmd); err != nil {
 		log.Fatalf("failed command: %s", color.Red("%v", err))
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package command
+
+import (
+	xc "os/exec"
+)
+
+// killProcessGroup does nothing without process groups, only the command itself is killed on cancellation
+func killProcessGroup(cmd *xc.Cmd) {}
+
+// restoreForeground does nothing without process groups, the command never takes over the terminal
+func restoreForeground(cmd *xc.Cmd) {}
@@ -0,0 +1,55 @@
+//go:build unix
+
+package command
+
+import (
+	xc "os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// killProcessGroup starts the command in its own process group, which is killed entirely on cancellation.
+// If plato runs in the foreground of a terminal, that group becomes the foreground process group of the terminal
+// until the command is done, so it can still prompt on it (like gpg's pinentry for pass does) instead of being
+// stopped by SIGTTIN or SIGTTOU. Call restoreForeground once the command is done to get the terminal back
+func killProcessGroup(cmd *xc.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if tty, ok := foregroundTerminal(); ok {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = tty
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// foregroundTerminal returns a descriptor of the controlling terminal, if plato's process group is in its foreground
+func foregroundTerminal() (int, bool) {
+	tty, err := unix.Open("/dev/tty", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return 0, false // no controlling terminal, nothing could prompt on it anyway
+	}
+	pgrp, err := unix.IoctlGetInt(tty, unix.TIOCGPGRP)
+	if err != nil || pgrp != unix.Getpgrp() {
+		_ = unix.Close(tty)
+		return 0, false
+	}
+	return tty, true
+}
+
+// restoreForeground makes plato's process group the foreground process group of the terminal again,
+// after a command started by killProcessGroup has taken it over
+func restoreForeground(cmd *xc.Cmd) {
+	if cmd.SysProcAttr == nil || !cmd.SysProcAttr.Foreground {
+		return
+	}
+	tty := cmd.SysProcAttr.Ctty
+	// plato is in a background process group by now, which would be stopped by SIGTTOU for changing that
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	_ = unix.IoctlSetPointerInt(tty, unix.TIOCSPGRP, unix.Getpgrp())
+	_ = unix.Close(tty)
+	cmd.SysProcAttr.Foreground = false
+}
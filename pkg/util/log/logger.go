@@ -86,6 +86,9 @@ func Fatal(format string, args ...interface{}) {
 	os.Exit(1)
 }
 func Fatalf(format string, args ...interface{}) {
+	if disabled { // still tell why we're exiting, on STDERR so it can't mix with rendered output on STDOUT
+		fmt.Fprintln(os.Stderr, fmt.Sprintf(format, args...))
+	}
 	logger.Error(fmt.Sprintf(format, args...))
 	os.Exit(1)
 }
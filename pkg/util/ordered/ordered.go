@@ -0,0 +1,267 @@
+package ordered
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Order keeps the original key order of maps, by their identity. It holds on to every map it knows,
+// so none of them can be garbage collected and their addresses reused by other maps while the order is in use.
+// Maps it doesn't know, like copies made by template functions, have their keys in sorted order
+type Order struct {
+	maps map[uintptr]entry
+}
+
+type entry struct {
+	m    map[string]any
+	keys []string
+}
+
+func NewOrder() *Order {
+	return &Order{maps: make(map[uintptr]entry)}
+}
+
+// Remember registers the original key order of a map, so encoders can later preserve it
+func (o *Order) Remember(m map[string]any, keys []string) {
+	if o == nil || m == nil {
+		return
+	}
+	o.maps[reflect.ValueOf(m).Pointer()] = entry{m: m, keys: keys}
+}
+
+// Knows checks if the key order of a map has been registered
+func (o *Order) Knows(m map[string]any) bool {
+	if o == nil || m == nil {
+		return false
+	}
+	_, ok := o.maps[reflect.ValueOf(m).Pointer()]
+	return ok
+}
+
+// Add registers the key order of all maps known by another order too
+func (o *Order) Add(other *Order) {
+	if o == nil || other == nil {
+		return
+	}
+	for key, e := range other.maps {
+		o.maps[key] = e
+	}
+}
+
+// Keys returns the keys of a map in their original order if known.
+// Any keys without a known order, or all of them for unknown maps, are appended in sorted order
+func (o *Order) Keys(m map[string]any) []string {
+	rest := make([]string, 0, len(m))
+	for key := range m {
+		rest = append(rest, key)
+	}
+	sort.Strings(rest)
+
+	var order []string
+	if o != nil && m != nil {
+		order = o.maps[reflect.ValueOf(m).Pointer()].keys
+	}
+
+	keys := make([]string, 0, len(m))
+	seen := make(map[string]bool, len(m))
+	for _, key := range order {
+		if _, exists := m[key]; exists && !seen[key] {
+			keys = append(keys, key)
+			seen[key] = true
+		}
+	}
+	for _, key := range rest {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Unmarshal decodes YAML (or JSON) into maps, slices and scalars,
+// keeping exact keys and remembering their original order in the given order, if any
+func Unmarshal(data []byte, order *Order) (any, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	if node.Kind == 0 {
+		return nil, nil // empty document
+	}
+	return FromNode(&node, order)
+}
+
+// FromNode converts a YAML node into maps, slices and scalars, remembering the key order of all maps in the given order, if any
+func FromNode(node *yaml.Node, order *Order) (any, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return FromNode(node.Content[0], order)
+	case yaml.AliasNode:
+		return FromNode(node.Alias, order)
+	case yaml.MappingNode:
+		m := make(map[string]any, len(node.Content)/2)
+		keys := make([]string, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+			// merge keys, "<<: *anchor"
+			if keyNode.Tag == "!!merge" {
+				merged, err := FromNode(valueNode, order)
+				if err != nil {
+					return nil, err
+				}
+				if mm, ok := merged.(map[string]any); ok {
+					for _, key := range order.Keys(mm) {
+						if _, exists := m[key]; !exists {
+							m[key] = mm[key]
+							keys = append(keys, key)
+						}
+					}
+				}
+				continue
+			}
+
+			var key any
+			if err := keyNode.Decode(&key); err != nil {
+				return nil, err
+			}
+			value, err := FromNode(valueNode, order)
+			if err != nil {
+				return nil, err
+			}
+			k := fmt.Sprintf("%v", key)
+			if _, exists := m[k]; !exists {
+				keys = append(keys, k)
+			}
+			m[k] = value
+		}
+		order.Remember(m, keys)
+		return m, nil
+	case yaml.SequenceNode:
+		list := make([]any, 0, len(node.Content))
+		for _, item := range node.Content {
+			value, err := FromNode(item, order)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	default:
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
+
+// UnmarshalJSON decodes JSON into maps, slices and scalars, remembering the original key order of all maps in the given order, if any.
+// Unlike Unmarshal it accepts all valid JSON, duplicate keys are resolved the same way as by encoding/json
+func UnmarshalJSON(data []byte, order *Order) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeJSON(decoder, order)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid character after top-level value")
+	}
+	return value, nil
+}
+
+func decodeJSON(decoder *json.Decoder, order *Order) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '[' {
+			list := make([]any, 0)
+			for decoder.More() {
+				value, err := decodeJSON(decoder, order)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, value)
+			}
+			_, err := decoder.Token()
+			return list, err
+		}
+
+		m := make(map[string]any)
+		keys := make([]string, 0)
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(decoder, order)
+			if err != nil {
+				return nil, err
+			}
+			k := key.(string)
+			if _, exists := m[k]; !exists {
+				keys = append(keys, k)
+			}
+			m[k] = value
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		order.Remember(m, keys)
+		return m, nil
+	case json.Number:
+		// same types as YAML would give: int if it fits, float64 otherwise
+		if i, err := strconv.ParseInt(token.String(), 10, 0); err == nil {
+			return int(i), nil
+		}
+		return token.Float64()
+	default:
+		return token, nil
+	}
+}
+
+// ToNode converts maps, slices and scalars into a YAML node, encoding maps in their key order known by the given order
+func ToNode(value any, order *Order) (*yaml.Node, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range order.Keys(v) {
+			child, err := ToNode(v[key], order)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+		}
+		return node, nil
+	case []any:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			child, err := ToNode(item, order)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	default:
+		node := &yaml.Node{}
+		if err := node.Encode(v); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+}
@@ -0,0 +1,104 @@
+package ordered
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func Test_Ordered_Unmarshal(t *testing.T) {
+	order := NewOrder()
+	object, err := Unmarshal([]byte(`
+zebra: 1
+Alpha:
+  app.kubernetes.io/name: plato
+  peer_asn: 4777444999
+base: &base
+  b: 1
+  a: 2
+merged:
+  <<: *base
+  c: 3
+`), order)
+	assert.NoError(t, err)
+
+	m := object.(map[string]any)
+	assert.Equal(t, []string{"zebra", "Alpha", "base", "merged"}, order.Keys(m))
+	assert.Equal(t, "plato", m["Alpha"].(map[string]any)["app.kubernetes.io/name"])
+	assert.Equal(t, 4777444999, m["Alpha"].(map[string]any)["peer_asn"])
+	assert.Equal(t, []string{"b", "a", "c"}, order.Keys(m["merged"].(map[string]any)))
+
+	// unknown keys are appended in sorted order
+	m["added"] = true
+	assert.Equal(t, []string{"zebra", "Alpha", "base", "merged", "added"}, order.Keys(m))
+	delete(m, "zebra")
+	assert.Equal(t, []string{"Alpha", "base", "merged", "added"}, order.Keys(m))
+	assert.Equal(t, []string{"x", "y"}, order.Keys(map[string]any{"y": 1, "x": 2}))
+
+	// copies are different maps, with sorted keys
+	copied := make(map[string]any)
+	for key, value := range m["merged"].(map[string]any) {
+		copied[key] = value
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, order.Keys(copied))
+
+	object, err = Unmarshal([]byte(""), order)
+	assert.NoError(t, err)
+	assert.Nil(t, object)
+}
+
+func Test_Ordered_ToNode(t *testing.T) {
+	order := NewOrder()
+	object, err := Unmarshal([]byte("zebra: 1\nalpha:\n  - b\n  - a: true\n"), order)
+	assert.NoError(t, err)
+
+	node, err := ToNode(object, order)
+	assert.NoError(t, err)
+	data, err := yaml.Marshal(node)
+	assert.NoError(t, err)
+	assert.Equal(t, "zebra: 1\nalpha:\n    - b\n    - a: true\n", string(data))
+}
+
+func Test_Ordered_UnmarshalJSON(t *testing.T) {
+	order := NewOrder()
+	object, err := UnmarshalJSON([]byte(`{"zebra": 1, "alpha": {"path": "a\/b", "big": 4777444999, "ratio": 0.5}, "zebra": [true, null]}`), order)
+	assert.NoError(t, err)
+
+	m := object.(map[string]any)
+	assert.Equal(t, []string{"zebra", "alpha"}, order.Keys(m))
+	assert.Equal(t, []any{true, nil}, m["zebra"])
+	assert.Equal(t, "a/b", m["alpha"].(map[string]any)["path"])
+	assert.Equal(t, 4777444999, m["alpha"].(map[string]any)["big"])
+	assert.Equal(t, 0.5, m["alpha"].(map[string]any)["ratio"])
+	assert.Equal(t, []string{"path", "big", "ratio"}, order.Keys(m["alpha"].(map[string]any)))
+
+	_, err = UnmarshalJSON([]byte(`{"a": 1} {"b": 2}`), nil)
+	assert.Error(t, err)
+	_, err = UnmarshalJSON([]byte(`{"a": }`), nil)
+	assert.Error(t, err)
+}
+
+func Test_Ordered_same_keys(t *testing.T) {
+	order := NewOrder()
+	object, err := Unmarshal([]byte("a: {x: 1, y: 2}\nb: {y: 1, x: 2}\n"), order)
+	assert.NoError(t, err)
+
+	m := object.(map[string]any)
+	assert.Equal(t, []string{"x", "y"}, order.Keys(m["a"].(map[string]any)))
+	assert.Equal(t, []string{"y", "x"}, order.Keys(m["b"].(map[string]any)))
+	assert.True(t, order.Knows(m))
+	assert.False(t, order.Knows(map[string]any{"y": 1, "x": 2}))
+	assert.Equal(t, []string{"x", "y"}, order.Keys(map[string]any{"y": 1, "x": 2}))
+
+	// the order of other decoded maps is only known once added
+	other := NewOrder()
+	object, err = UnmarshalJSON([]byte(`{"z": 1, "a": 2}`), other)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "z"}, order.Keys(object.(map[string]any)))
+	order.Add(other)
+	assert.Equal(t, []string{"z", "a"}, order.Keys(object.(map[string]any)))
+
+	var none *Order
+	assert.Equal(t, []string{"x", "y"}, none.Keys(map[string]any{"y": 1, "x": 2}))
+}